@@ -0,0 +1,123 @@
+// Package aozora は青空文庫から収集した作品を保存・検索するためのパッケージ
+//
+// aozora-collector, aozora-search などのツールはこのパッケージを通して
+// 同じスキーマ、同じクエリでデータベースを扱う。
+package aozora
+
+import "errors"
+
+// ErrNotFound は指定した作品が見つからない場合に返される
+var ErrNotFound = errors.New("aozora: not found")
+
+// Entry は青空文庫の作品一件分の情報
+type Entry struct {
+	AuthorID string
+	Author   string
+	TitleID  string
+	Title    string
+	SiteURL  string // 図書カード (作品ページ) の URL
+	ZipURL   string
+
+	// 以下は作品の詳細な情報。取得元によっては空になる
+	AuthorYomi      string // 作者名の読み
+	AuthorRomaji    string // 作者名のローマ字表記
+	BirthDate       string // 作者の生年月日
+	DeathDate       string // 作者の没年月日
+	TitleYomi       string // 作品名の読み
+	TitleSort       string // 作品名のソート用の読み
+	Subtitle        string // 副題
+	OriginalTitle   string // 原題
+	FirstAppearance string // 初出
+	CharType        string // 文字遣い種別 (新字新仮名など)
+	Translator      string // 翻訳者
+	Copyright       bool   // 作品の著作権が存続しているか
+	ReleaseDate     string // 青空文庫での公開日
+}
+
+// Image は作品と一緒に配布されている挿絵
+type Image struct {
+	Name string // ZIP ファイルの中のファイル名
+	Data []byte
+}
+
+// FetchState は作品の ZIP ファイルを前回ダウンロードした時の HTTP の検証子と内容のハッシュ
+//
+// 次に収集する時に条件付きリクエストを送り、変わっていない作品を読み飛ばすのに使う。
+type FetchState struct {
+	AuthorID     string
+	TitleID      string
+	ZipURL       string
+	ETag         string
+	LastModified string
+	Hash         string // ZIP ファイルの SHA-256 (16 進数)
+}
+
+// Author は作者
+type Author struct {
+	ID        string
+	Name      string
+	Yomi      string // 作者名の読み
+	Romaji    string // 作者名のローマ字表記
+	BirthDate string // 生年月日
+	DeathDate string // 没年月日
+}
+
+// Title は作品のタイトル
+type Title struct {
+	AuthorID string
+	ID       string
+	Title    string
+}
+
+// Hit は全文検索にマッチした作品
+type Hit struct {
+	AuthorID string
+	Author   string
+	TitleID  string
+	Title    string
+	Snippet  string // マッチした語の周りの本文。マッチした語は SearchOptions の Open と Close で囲む
+}
+
+// SearchOptions は全文検索の結果の範囲と抜粋の書き方
+type SearchOptions struct {
+	Limit  int    // 返す件数。0 以下の場合は全件
+	Offset int    // 読み飛ばす件数
+	Open   string // マッチした語の前に付ける文字列。空の場合は "【"
+	Close  string // マッチした語の後に付ける文字列。空の場合は "】"
+}
+
+// 作品の並べ方
+const (
+	SortByID      = "id"      // 作者 ID と作品 ID の順
+	SortByTitle   = "title"   // 作品名の五十音順
+	SortByYear    = "year"    // 初出の年の順
+	SortByRelease = "release" // 青空文庫での公開日の順
+)
+
+// WorkSorts は WorkFilter.Sort に指定できる並べ方の一覧
+var WorkSorts = []string{SortByID, SortByTitle, SortByYear, SortByRelease}
+
+// WorkFilter は作品の一覧を絞り込む条件。ゼロ値の項目は条件にしない
+type WorkFilter struct {
+	AuthorID   string
+	CharType   string // 文字遣い種別
+	Translated bool   // 翻訳作品だけにする
+	FromYear   int    // 初出の年がこの年以降
+	ToYear     int    // 初出の年がこの年以前
+	Copyright  *bool  // 作品の著作権が存続しているか
+	Sort       string // 並べ方。空の場合は SortByID
+}
+
+// Store は作品の保存先
+type Store interface {
+	// AddEntry は作品を保存し、全文検索のインデックスに登録する
+	AddEntry(entry *Entry, content string) error
+	// Authors は作者の一覧を返す
+	Authors() ([]Author, error)
+	// Titles は作者の作品の一覧を返す
+	Titles(authorID string) ([]Title, error)
+	// Content は作品の本文を返す。見つからない場合は ErrNotFound を返す
+	Content(authorID, titleID string) (string, error)
+	// Search は全文検索にマッチした作品の一覧を返す
+	Search(query string) ([]Hit, error)
+}
@@ -0,0 +1,99 @@
+package aozora
+
+import "database/sql"
+
+// Batch は複数の作品をまとめて一つのトランザクションで保存する
+//
+// 大量の作品を登録する場合に、作品ごとにコミットするよりも速い。size 件ごとに
+// コミットし、最後に Flush で残りをコミットする。作品ごとの保存はセーブポイントで
+// 区切るので、一件の保存に失敗してもその作品だけが取り消される。
+// Batch は複数の goroutine から同時に使ってはならない。
+type Batch struct {
+	s    *SQLiteStore
+	size int
+	tx   *sql.Tx
+	n    int
+}
+
+// NewBatch は size 件ごとにコミットする Batch を作る
+func (s *SQLiteStore) NewBatch(size int) *Batch {
+	if size < 1 {
+		size = 1
+	}
+	return &Batch{s: s, size: size}
+}
+
+// AddEntry は作品を分かち書きしてバッチに追加する
+func (b *Batch) AddEntry(entry *Entry, content string) error {
+	return b.AddDocument(b.s.indexer.Document(entry, content))
+}
+
+// AddDocument は分かち書き済みの作品をバッチに追加する
+func (b *Batch) AddDocument(doc *Document) error {
+	return b.add(func(tx *sql.Tx) error {
+		return addDocument(tx, doc)
+	}, true)
+}
+
+// SaveFetchState は作品の検証子とハッシュだけをバッチに追加する
+func (b *Batch) SaveFetchState(state *FetchState) error {
+	return b.add(func(tx *sql.Tx) error {
+		return saveFetchState(tx, state)
+	}, true)
+}
+
+// SetCrawlStatus は作品の収集の状態をバッチに追加する
+//
+// 作品の数には数えず、次に追加する作品と同じトランザクションでコミットする。
+// 作品より先に呼べば、中断しても作品と状態が食い違わない。
+func (b *Batch) SetCrawlStatus(authorID, titleID string, status CrawlStatus, reason string) error {
+	return b.add(func(tx *sql.Tx) error {
+		return setCrawlStatus(tx, authorID, titleID, status, reason)
+	}, false)
+}
+
+// add は一件分の保存をセーブポイントで区切って実行する。count が真なら作品の数に数える
+func (b *Batch) add(save func(tx *sql.Tx) error, count bool) error {
+	if b.tx == nil {
+		tx, err := b.s.db.Begin()
+		if err != nil {
+			return err
+		}
+		b.tx = tx
+	}
+
+	_, err := b.tx.Exec(`SAVEPOINT entry`)
+	if err != nil {
+		return err
+	}
+	err = save(b.tx)
+	if err != nil {
+		b.tx.Exec(`ROLLBACK TO entry`)
+		b.tx.Exec(`RELEASE entry`)
+		return err
+	}
+	_, err = b.tx.Exec(`RELEASE entry`)
+	if err != nil {
+		return err
+	}
+
+	if !count {
+		return nil
+	}
+	b.n++
+	if b.n >= b.size {
+		return b.Flush()
+	}
+	return nil
+}
+
+// Flush はバッチに溜まっている作品をコミットする
+func (b *Batch) Flush() error {
+	if b.tx == nil {
+		return nil
+	}
+	tx := b.tx
+	b.tx = nil
+	b.n = 0
+	return tx.Commit()
+}
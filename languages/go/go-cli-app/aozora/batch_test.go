@@ -0,0 +1,31 @@
+package aozora
+
+import "testing"
+
+func TestBatch(t *testing.T) {
+	store := openTestStore(t)
+
+	batch := store.NewBatch(2)
+	for _, id := range []string{"001", "002", "003"} {
+		err := batch.AddEntry(&Entry{AuthorID: "999999", Author: "テスト 太郎", TitleID: id, Title: "テスト書籍" + id}, "hello world")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := countRows(t, store, "contents"); n != 2 {
+		t.Errorf("want 2 committed rows before Flush, but got %d", n)
+	}
+
+	err := batch.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countRows(t, store, "contents"); n != 3 {
+		t.Errorf("want 3 rows after Flush, but got %d", n)
+	}
+	if n := countRows(t, store, "contents_fts"); n != 3 {
+		t.Errorf("want 3 rows in contents_fts after Flush, but got %d", n)
+	}
+}
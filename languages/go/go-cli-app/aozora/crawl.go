@@ -0,0 +1,124 @@
+package aozora
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// CrawlStatus は収集の途中経過での作品の状態
+type CrawlStatus string
+
+const (
+	CrawlPending CrawlStatus = "pending" // まだ収集していない
+	CrawlDone    CrawlStatus = "done"    // 保存した
+	CrawlFailed  CrawlStatus = "failed"  // 失敗した
+)
+
+// CrawlItem は収集の途中経過に記録した作品一件分
+type CrawlItem struct {
+	Entry  Entry
+	Status CrawlStatus
+	Error  string // 失敗した理由
+}
+
+// StartCrawl は以前の途中経過を消し、items をその状態のまま記録する
+//
+// 収集を中断した場合は CrawlItems で途中経過を読み出して再開する。
+// 作品 ID の無い項目 (取得できなかった作品一覧のページなど) は SiteURL で区別する。
+func (s *SQLiteStore) StartCrawl(items []CrawlItem) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM crawl`)
+	if err != nil {
+		return err
+	}
+	for i, item := range items {
+		b, err := json.Marshal(&item.Entry)
+		if err != nil {
+			return err
+		}
+		authorID, titleID := item.Entry.AuthorID, item.Entry.TitleID
+		if authorID == "" && titleID == "" {
+			titleID = item.Entry.SiteURL
+		}
+		_, err = tx.Exec(`
+			INSERT OR IGNORE INTO crawl(seq, author_id, title_id, entry, status, error) values(?, ?, ?, ?, ?, ?)
+		`,
+			i,
+			authorID,
+			titleID,
+			string(b),
+			item.Status,
+			item.Error,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CrawlItems は記録した途中経過を StartCrawl に渡した順に返す
+func (s *SQLiteStore) CrawlItems() ([]CrawlItem, error) {
+	rows, err := s.db.Query(`
+		SELECT
+			c.entry,
+			c.status,
+			c.error
+		FROM
+			crawl c
+		ORDER BY
+			c.seq
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []CrawlItem{}
+	for rows.Next() {
+		var item CrawlItem
+		var entry string
+		err = rows.Scan(&entry, &item.Status, &item.Error)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(entry), &item.Entry)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// SetCrawlStatus は作品の収集の状態を記録する
+func (s *SQLiteStore) SetCrawlStatus(authorID, titleID string, status CrawlStatus, reason string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = setCrawlStatus(tx, authorID, titleID, status, reason)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func setCrawlStatus(tx *sql.Tx, authorID, titleID string, status CrawlStatus, reason string) error {
+	_, err := tx.Exec(`
+		UPDATE crawl SET status = ?, error = ? WHERE author_id = ? AND title_id = ?
+	`,
+		status,
+		reason,
+		authorID,
+		titleID,
+	)
+	return err
+}
@@ -0,0 +1,94 @@
+package aozora
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ftsDialect は FTS のモジュールごとに異なる全文検索の書き方
+type ftsDialect struct {
+	module  string
+	rank    string // 検索結果の並べ方
+	snippet string // open、close、トークン数のプレースホルダを取る snippet の式
+	query   func(query string) string
+}
+
+// fts4Dialect は FTS4 の書き方。FTS4 には bm25 が無いので作品 ID の数値の順に並べる
+var fts4Dialect = &ftsDialect{
+	module:  "fts4",
+	rank:    `CAST(c.author_id AS INTEGER), CAST(c.title_id AS INTEGER)`,
+	snippet: `snippet(contents_fts, ?, ?, '…', -1, ?)`,
+	query:   func(query string) string { return query },
+}
+
+var ftsModulePat = regexp.MustCompile(`(?i)\bUSING\s+(fts[0-9])\b`)
+
+// tableFTSModule は全文検索のインデックスに使っている FTS のモジュール (fts4 か fts5) を返す
+func tableFTSModule(db *sql.DB) (string, error) {
+	var query string
+	err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'contents_fts'`).Scan(&query)
+	if err != nil {
+		return "", err
+	}
+	m := ftsModulePat.FindStringSubmatch(query)
+	if m == nil {
+		return "", fmt.Errorf("aozora: unknown fts module: %s", query)
+	}
+	return strings.ToLower(m[1]), nil
+}
+
+// openFTS は全文検索のインデックスのモジュールに合った書き方を返す
+//
+// FTS5 でビルドしても FTS4 のインデックスはそのまま読む。FTS4 でビルドした場合は
+// FTS5 のインデックスを読めないので ErrFTS5Required を返す。
+func openFTS(db *sql.DB) (*ftsDialect, error) {
+	module, err := tableFTSModule(db)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case module == fts4Dialect.module:
+		return fts4Dialect, nil
+	case module == "fts5" && fts5Dialect != nil:
+		return fts5Dialect, nil
+	case module == "fts5":
+		return nil, fmt.Errorf("%w: the database uses fts5", ErrFTS5Required)
+	}
+	return nil, fmt.Errorf("aozora: unknown fts module: %s", module)
+}
+
+// MigrateFTS5 は FTS4 で作った全文検索のインデックスを、rowid を保ったまま FTS5 に移す
+//
+// 移すと検索結果を bm25 で並べられるが、FTS4 でビルドしたプログラムでは開けなくなるので、
+// データベースを開いただけでは移さない。既に FTS5 の場合は何もしない。
+func (s *SQLiteStore) MigrateFTS5() error {
+	if fts5Dialect == nil {
+		return ErrFTS5Required
+	}
+	if s.fts == fts5Dialect {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = execAll(
+		`CREATE VIRTUAL TABLE contents_fts5 USING fts5(words)`,
+		`INSERT INTO contents_fts5(rowid, words) SELECT rowid, words FROM contents_fts`,
+		`DROP TABLE contents_fts`,
+		`ALTER TABLE contents_fts5 RENAME TO contents_fts`,
+	)(tx)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrate to fts5: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	s.fts = fts5Dialect
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !sqlite_fts5
+
+package aozora
+
+// FTS5 はこのビルドで FTS5 を使えるか。-tags sqlite_fts5 でビルドした場合に true になる
+//
+// タグを付けない場合は FTS4 のインデックスしか読めず、検索結果を bm25 で並べられず、
+// NEAR(a b, N) のような FTS5 の構文も使えない。
+const FTS5 = false
+
+// fts5Dialect は FTS5 の書き方。このビルドでは FTS5 を使えないので nil
+var fts5Dialect *ftsDialect
@@ -0,0 +1,103 @@
+//go:build sqlite_fts5
+
+package aozora
+
+import (
+	"strconv"
+	"strings"
+)
+
+// FTS5 はこのビルドで FTS5 を使えるか。-tags sqlite_fts5 でビルドした場合に true になる
+//
+// FTS5 を使うのは MigrateFTS5 でインデックスを移した後で、それまでは FTS4 のまま読む。
+const FTS5 = true
+
+// fts5Dialect は FTS5 の書き方。bm25 は関連が高いほど小さい
+var fts5Dialect = &ftsDialect{
+	module:  "fts5",
+	rank:    `bm25(contents_fts), CAST(c.author_id AS INTEGER), CAST(c.title_id AS INTEGER)`,
+	snippet: `snippet(contents_fts, 0, ?, ?, '…', ?)`,
+	query:   ftsQuery,
+}
+
+// ftsQuery は検索式を FTS5 の構文にする
+//
+// FTS4 の a NEAR/N b は FTS5 では NEAR(a b, N) と書く。a NEAR b NEAR c のように
+// 続く場合は一つの NEAR にまとめ、距離は最も大きいものにする。
+// FTS5 では記号を含む検索語はダブルクォートで囲む必要がある。
+func ftsQuery(query string) string {
+	terms := splitQuery(query)
+	for i, term := range terms {
+		terms[i] = quoteBareword(term)
+	}
+	out := []string{}
+	for i := 0; i < len(terms); i++ {
+		distance, ok := nearDistance(terms[i])
+		if !ok || len(out) == 0 || i+1 == len(terms) || !isPhrase(out[len(out)-1]) || !isPhrase(terms[i+1]) {
+			out = append(out, terms[i])
+			continue
+		}
+		phrases := []string{out[len(out)-1], terms[i+1]}
+		i++
+		for i+2 < len(terms) && isPhrase(terms[i+2]) {
+			d, ok := nearDistance(terms[i+1])
+			if !ok {
+				break
+			}
+			if d != "" && (distance == "" || atoi(d) > atoi(distance)) {
+				distance = d
+			}
+			phrases = append(phrases, terms[i+2])
+			i += 2
+		}
+		near := "NEAR(" + strings.Join(phrases, " ")
+		if distance != "" {
+			near += ", " + distance
+		}
+		out[len(out)-1] = near + ")"
+	}
+	return strings.Join(out, " ")
+}
+
+// nearDistance は NEAR か NEAR/N の場合に N を返す。N が無い場合は空文字列を返す
+func nearDistance(term string) (string, bool) {
+	if term == "NEAR" {
+		return "", true
+	}
+	distance, ok := strings.CutPrefix(term, "NEAR/")
+	if !ok || distance == "" || strings.Trim(distance, "0123456789") != "" {
+		return "", false
+	}
+	return distance, true
+}
+
+// isPhrase は term が括弧や演算子ではない検索語か
+func isPhrase(term string) bool {
+	switch {
+	case term == "AND" || term == "OR" || term == "NOT":
+		return false
+	case strings.ContainsAny(term, "()"):
+		return false
+	}
+	_, near := nearDistance(term)
+	return !near
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// quoteBareword は FTS5 の bareword に使えない記号を含む検索語をダブルクォートで囲む
+func quoteBareword(term string) string {
+	if !isPhrase(term) || strings.HasPrefix(term, `"`) {
+		return term
+	}
+	body := strings.TrimSuffix(term, "*")
+	for _, r := range body {
+		if r < 0x80 && !(r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r == '_') {
+			return `"` + body + `"` + term[len(body):]
+		}
+	}
+	return term
+}
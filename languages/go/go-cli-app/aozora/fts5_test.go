@@ -0,0 +1,98 @@
+//go:build sqlite_fts5
+
+package aozora
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "猫 AND 犬", want: "猫 AND 犬"},
+		{query: "吾輩 NEAR/3 猫", want: "NEAR(吾輩 猫, 3)"},
+		{query: "吾輩 NEAR 猫", want: "NEAR(吾輩 猫)"},
+		{query: `"吾輩 は" NEAR/2 猫 NEAR/5 犬`, want: `NEAR("吾輩 は" 猫 犬, 5)`},
+		{query: "吾輩 NEAR/3 猫 OR 犬", want: "NEAR(吾輩 猫, 3) OR 犬"},
+		{query: "NEAR/3 猫", want: "NEAR/3 猫"},
+		{query: "! OR 猫", want: `"!" OR 猫`},
+		{query: "hello*", want: "hello*"},
+		{query: "e-mail*", want: `"e-mail"*`},
+	}
+	for _, test := range tests {
+		got := ftsQuery(test.query)
+		if got != test.want {
+			t.Errorf("%q: want %q, but got %q", test.query, test.want, got)
+		}
+	}
+}
+
+func TestMigrateFTS5(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.sqlite")
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := Entry{AuthorID: "999999", Author: "テスト 太郎", TitleID: "001", Title: "テスト書籍001"}
+	err = store.AddEntry(&entry, "吾輩は猫である。")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 開いただけでは FTS4 のまま読む
+	search := func(store *SQLiteStore) {
+		t.Helper()
+		hits, err := store.Search("猫")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hits) != 1 || hits[0].TitleID != "001" {
+			t.Errorf("want 1 hit, but got %+v", hits)
+		}
+	}
+	search(store)
+	if store.fts != fts4Dialect {
+		t.Errorf("want fts4, but got %s", store.fts.module)
+	}
+
+	// 二回移しても変わらない
+	for i := 0; i < 2; i++ {
+		err = store.MigrateFTS5()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	search(store)
+	store.Close()
+
+	// 開き直すと FTS5 のインデックスとして読み、バージョンは変わらない
+	store, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var sql string
+	err = store.DB().QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'contents_fts'`).Scan(&sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "CREATE VIRTUAL TABLE \"contents_fts\" USING fts5(words)"
+	if sql != want {
+		t.Errorf("want %q, but got %q", want, sql)
+	}
+	if store.fts != fts5Dialect {
+		t.Errorf("want fts5, but got %s", store.fts.module)
+	}
+	version, err := Version(store.DB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != SchemaVersion {
+		t.Errorf("want version %d, but got %d", SchemaVersion, version)
+	}
+	search(store)
+}
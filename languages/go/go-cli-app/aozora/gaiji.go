@@ -0,0 +1,110 @@
+package aozora
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// GaijiPlaceholder は Unicode の文字に置き換えられなかった外字の代わりに置く文字
+const GaijiPlaceholder = "〓"
+
+// Gaiji は ※［＃…］ の外字の注記を解決した結果
+type Gaiji struct {
+	Note        string // 注記 (［＃ と ］ の間)
+	Description string // 「てへん＋劣」 のような字形の説明
+	Ref         string // 1-84-77 のような面区点位置、または U+6318 のような符号位置
+	Char        string // 置き換えた文字。解決できなかった場合は空
+}
+
+// Resolved は外字を Unicode の文字に置き換えられたかどうかを返す
+func (g *Gaiji) Resolved() bool {
+	return g.Char != ""
+}
+
+//go:embed jisx0213.txt
+var jisx0213Table string
+
+var (
+	jisx0213Once sync.Once
+	jisx0213     map[string]string
+)
+
+// loadJISX0213 は JIS X 0213 の面区点位置から Unicode の文字への対応表を読み込む
+func loadJISX0213() map[string]string {
+	jisx0213Once.Do(func() {
+		jisx0213 = map[string]string{}
+		scanner := bufio.NewScanner(strings.NewReader(jisx0213Table))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			ref, codes, ok := strings.Cut(line, " ")
+			if !ok {
+				continue
+			}
+			var b strings.Builder
+			for _, code := range strings.Split(codes, "+") {
+				r, err := strconv.ParseUint(code, 16, 32)
+				if err != nil {
+					continue
+				}
+				b.WriteRune(rune(r))
+			}
+			jisx0213[ref] = b.String()
+		}
+	})
+	return jisx0213
+}
+
+// JISX0213 は JIS X 0213 の面区点位置の文字を返す。対応表に無い場合は false を返す
+func JISX0213(plane, row, cell int) (string, bool) {
+	char, ok := loadJISX0213()[fmt.Sprintf("%d-%d-%d", plane, row, cell)]
+	return char, ok
+}
+
+var (
+	gaijiDescPat    = regexp.MustCompile(`^「([^」]*)」`)
+	gaijiUnicodePat = regexp.MustCompile(`U\+([0-9A-Fa-f]{4,6})`)
+	gaijiJISPat     = regexp.MustCompile(`(?:第[34]水準)?([12])-([0-9]{1,2})-([0-9]{1,2})`)
+)
+
+// fullwidthDigits は全角の数字と記号を半角にする
+var fullwidthDigits = strings.NewReplacer(
+	"０", "0", "１", "1", "２", "2", "３", "3", "４", "4",
+	"５", "5", "６", "6", "７", "7", "８", "8", "９", "9",
+	"－", "-", "‐", "-", "Ｕ", "U", "＋", "+",
+)
+
+// ResolveGaiji は外字の注記の中身から Unicode の文字を探す
+//
+// 注記に U+XXXX の符号位置があればそれを使い、無ければ 第3水準1-84-77 のような
+// JIS X 0213 の面区点位置を対応表で引く。
+func ResolveGaiji(note string) *Gaiji {
+	g := &Gaiji{Note: note}
+	if m := gaijiDescPat.FindStringSubmatch(note); m != nil {
+		g.Description = m[1]
+	}
+
+	n := fullwidthDigits.Replace(note)
+	if m := gaijiUnicodePat.FindStringSubmatch(n); m != nil {
+		g.Ref = "U+" + strings.ToUpper(m[1])
+		r, err := strconv.ParseUint(m[1], 16, 32)
+		if err == nil && r <= 0x10FFFF {
+			g.Char = string(rune(r))
+		}
+		return g
+	}
+	if m := gaijiJISPat.FindStringSubmatch(n); m != nil {
+		row, _ := strconv.Atoi(m[2])
+		cell, _ := strconv.Atoi(m[3])
+		g.Ref = m[1] + "-" + strconv.Itoa(row) + "-" + strconv.Itoa(cell)
+		g.Char = loadJISX0213()[g.Ref]
+	}
+	return g
+}
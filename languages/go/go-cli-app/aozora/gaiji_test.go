@@ -0,0 +1,60 @@
+package aozora
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveGaiji(t *testing.T) {
+	tests := []struct {
+		note string
+		want Gaiji
+	}{
+		{
+			note: "「てへん＋劣」、第3水準1-84-77",
+			want: Gaiji{Description: "てへん＋劣", Ref: "1-84-77", Char: "挘"},
+		},
+		{
+			note: "「丿＋乚」、第４水準２－１－１",
+			want: Gaiji{Description: "丿＋乚", Ref: "2-1-1", Char: "\U00020089"},
+		},
+		{
+			note: "「虫＋(廷－壬)」、U+8712、140-7",
+			want: Gaiji{Description: "虫＋(廷－壬)", Ref: "U+8712", Char: "蜒"},
+		},
+		{
+			// 面区点位置も符号位置も無い外字は置き換えられない
+			note: "「(口／耳)＋頁」、140-7",
+			want: Gaiji{Description: "(口／耳)＋頁"},
+		},
+	}
+	for _, tt := range tests {
+		got := ResolveGaiji(tt.note)
+		tt.want.Note = tt.note
+		if !reflect.DeepEqual(&tt.want, got) {
+			t.Errorf("%q: want %+v, but got %+v", tt.note, tt.want, got)
+		}
+	}
+}
+
+func TestParseTextGaiji(t *testing.T) {
+	text := ParseText("※［＃「てへん＋劣」、第3水準1-84-77］《むし》り取る※［＃「(口／耳)＋頁」、140-7］")
+
+	want := []Segment{
+		{Text: "挘", Ruby: "むし"},
+		{Text: "り取る"},
+		{Text: GaijiPlaceholder, Gaiji: text.Gaiji[1]},
+	}
+	if !reflect.DeepEqual(want, text.Body) {
+		t.Errorf("want %+v, but got %+v", want, text.Body)
+	}
+	if len(text.Gaiji) != 2 || !text.Gaiji[0].Resolved() || text.Gaiji[1].Resolved() {
+		t.Errorf("want one resolved and one unresolved gaiji, but got %+v", text.Gaiji)
+	}
+
+	html := text.HTML()
+	wantHTML := `<ruby><rb>挘</rb><rt>むし</rt></ruby>り取る<span class="gaiji" title="「(口／耳)＋頁」、140-7">〓</span>`
+	if html != wantHTML {
+		t.Errorf("want %q, but got %q", wantHTML, html)
+	}
+}
@@ -0,0 +1,148 @@
+package aozora
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/ikawaha/kagome-dict/ipa"
+	"github.com/ikawaha/kagome/v2/tokenizer"
+)
+
+// Indexer は本文を全文検索用の単語に分割する
+//
+// 辞書の読み込みは NewIndexer の一度だけで済むように、作った Indexer を使い回すこと。
+// Indexer は複数の goroutine から同時に使ってよい。
+type Indexer struct {
+	t *tokenizer.Tokenizer
+}
+
+// NewIndexer は IPA 辞書を使った Indexer を作る
+func NewIndexer() (*Indexer, error) {
+	t, err := tokenizer.New(ipa.Dict(), tokenizer.OmitBosEos())
+	if err != nil {
+		return nil, err
+	}
+	return &Indexer{t: t}, nil
+}
+
+// Document は全文検索用の単語に分割済みの作品
+type Document struct {
+	Entry   *Entry
+	Content string
+	Words   []string
+	Images  []Image
+	Fetch   *FetchState // nil でなければ作品と一緒に保存する
+}
+
+// Words は content を分かち書きした単語の列を返す。空白だけの単語は含まない
+func (ix *Indexer) Words(content string) []string {
+	seg := ix.t.Wakati(content)
+	words := seg[:0]
+	for _, word := range seg {
+		if strings.TrimSpace(word) == "" {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words
+}
+
+// Morpheme は形態素解析した一語
+type Morpheme struct {
+	Surface  string
+	BaseForm string   // 原形。辞書に無い語では Surface と同じ
+	POS      []string // 品詞を大分類から順に並べたもの。"*" は含まない
+	Start    int      // 解析した文字列での開始位置 (rune 単位)
+	End      int      // 解析した文字列での終了位置 (rune 単位)
+}
+
+// Morphemes は text を形態素解析した語の列を返す。空白だけの語は含まない
+func (ix *Indexer) Morphemes(text string) []Morpheme {
+	morphemes := []Morpheme{}
+	for _, tok := range ix.t.Tokenize(text) {
+		if strings.TrimSpace(tok.Surface) == "" {
+			continue
+		}
+		m := Morpheme{Surface: tok.Surface, BaseForm: tok.Surface, Start: tok.Start, End: tok.End}
+		if base, ok := tok.BaseForm(); ok && base != "*" {
+			m.BaseForm = base
+		}
+		for _, pos := range tok.POS() {
+			if pos != "*" {
+				m.POS = append(m.POS, pos)
+			}
+		}
+		morphemes = append(morphemes, m)
+	}
+	return morphemes
+}
+
+// Query は全文検索のクエリの検索語を本文と同じように分かち書きする
+//
+// AND, OR, NOT, NEAR などの演算子と括弧はそのまま残す。複数の単語に分かれた
+// 検索語はフレーズ検索になる。末尾が * の前方一致の検索語は分かち書きしない。
+func (ix *Indexer) Query(query string) string {
+	terms := []string{}
+	for _, term := range splitQuery(query) {
+		terms = append(terms, ix.queryTerm(term))
+	}
+	return strings.Join(terms, " ")
+}
+
+func (ix *Indexer) queryTerm(term string) string {
+	// 括弧を外して検索語だけを分かち書きする
+	open := len(term) - len(strings.TrimLeft(term, "("))
+	term = term[open:]
+	body := strings.TrimRight(term, ")")
+	closing := term[len(body):]
+
+	switch {
+	case body == "":
+	case body == "AND" || body == "OR" || body == "NOT" || strings.HasPrefix(body, "NEAR"):
+	case strings.HasSuffix(body, "*"):
+	default:
+		words := ix.Words(strings.Trim(body, `"`))
+		if len(words) == 1 && !strings.HasPrefix(body, `"`) {
+			body = words[0]
+		} else {
+			body = `"` + strings.Join(words, " ") + `"`
+		}
+	}
+	return strings.Repeat("(", open) + body + closing
+}
+
+// splitQuery はクエリを空白で区切る。ダブルクォートで囲まれた部分は区切らない
+func splitQuery(query string) []string {
+	terms := []string{}
+	var b strings.Builder
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			b.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if b.Len() > 0 {
+				terms = append(terms, b.String())
+				b.Reset()
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() > 0 {
+		terms = append(terms, b.String())
+	}
+	return terms
+}
+
+// Document は作品を分かち書きして Document を作る
+//
+// 全文検索の対象は表題、ルビ、注記、底本の情報を取り除いた本文だけにする。
+func (ix *Indexer) Document(entry *Entry, content string) *Document {
+	return &Document{
+		Entry:   entry,
+		Content: content,
+		Words:   ix.Words(ParseText(content).Plain()),
+	}
+}
@@ -0,0 +1,27 @@
+package aozora
+
+import "testing"
+
+func TestIndexerQuery(t *testing.T) {
+	ix, err := NewIndexer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "虫 AND ココア", want: "虫 AND ココア"},
+		{query: "吾輩は猫", want: `"吾輩 は 猫"`},
+		{query: `"名前はまだ"`, want: `"名前 は まだ"`},
+		{query: "(猫 OR 犬) NOT 吾輩は", want: `(猫 OR 犬) NOT "吾輩 は"`},
+		{query: "ココ*", want: "ココ*"},
+	}
+	for _, tt := range tests {
+		got := ix.Query(tt.query)
+		if got != tt.want {
+			t.Errorf("%q: want %q, but got %q", tt.query, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package aozora
+
+import (
+	"strings"
+)
+
+// KWICOptions は KWIC で一覧にする語の条件と文脈の長さ
+type KWICOptions struct {
+	Width    int    // 前後の文脈の文字数。0 以下の場合は 20
+	Lemma    bool   // 表層形ではなく原形で比べる。見る で 見た や 見れば も一覧にする
+	POS      string // 品詞。動詞 や 名詞-固有名詞 のように大分類から - でつないだものの前方一致
+	AuthorID string // 作者の作品だけにする
+}
+
+// Concordance は KWIC の一行分
+type Concordance struct {
+	AuthorID string
+	Author   string
+	TitleID  string
+	Title    string
+	Left     string // 語の前の文脈。改行は空白にする
+	Keyword  string // 本文に現れた語
+	Right    string // 語の後の文脈。改行は空白にする
+	BaseForm string
+	POS      string // 品詞を - でつないだもの
+}
+
+// KWIC は word が本文に現れる箇所を、前後の文脈と一緒に作品の順に返す
+//
+// word は一語として比べる。原形で比べる場合は word を形態素解析した最初の語の原形を
+// 使うので、見た や 見れば のように活用した形で指定してもよい。表層形で比べる場合は
+// 全文検索で作品を絞り込んでから形態素解析する。
+func (s *SQLiteStore) KWIC(word string, opts KWICOptions) ([]Concordance, error) {
+	if opts.Width <= 0 {
+		opts.Width = 20
+	}
+	if opts.Lemma {
+		if ms := s.indexer.Morphemes(word); len(ms) > 0 {
+			word = ms[0].BaseForm
+		}
+	}
+
+	works, err := s.kwicWorks(word, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []Concordance{}
+	for _, w := range works {
+		content, err := s.Content(w.AuthorID, w.TitleID)
+		if err != nil {
+			return nil, err
+		}
+		text := []rune(strings.ReplaceAll(ParseText(content).Plain(), "\n", " "))
+		for _, m := range s.indexer.Morphemes(string(text)) {
+			key := m.Surface
+			if opts.Lemma {
+				key = m.BaseForm
+			}
+			pos := strings.Join(m.POS, "-")
+			if key != word || !strings.HasPrefix(pos, opts.POS) {
+				continue
+			}
+			lines = append(lines, Concordance{
+				AuthorID: w.AuthorID,
+				Author:   w.Author,
+				TitleID:  w.TitleID,
+				Title:    w.Title,
+				Left:     string(text[max(m.Start-opts.Width, 0):m.Start]),
+				Keyword:  m.Surface,
+				Right:    string(text[m.End:min(m.End+opts.Width, len(text))]),
+				BaseForm: m.BaseForm,
+				POS:      pos,
+			})
+		}
+	}
+	return lines, nil
+}
+
+// kwicWorks は word が現れる可能性のある作品を作品 ID の順に返す
+func (s *SQLiteStore) kwicWorks(word string, opts KWICOptions) ([]Entry, error) {
+	works, err := s.Works(WorkFilter{AuthorID: opts.AuthorID})
+	if err != nil || opts.Lemma {
+		// 活用した形は全文検索で探せないので、すべての作品を調べる
+		return works, err
+	}
+	hits, err := s.SearchWith(`"`+strings.ReplaceAll(word, `"`, "")+`"`, SearchOptions{})
+	if err != nil {
+		return nil, err
+	}
+	found := map[[2]string]bool{}
+	for _, hit := range hits {
+		found[[2]string{hit.AuthorID, hit.TitleID}] = true
+	}
+	candidates := []Entry{}
+	for _, w := range works {
+		if found[[2]string{w.AuthorID, w.TitleID}] {
+			candidates = append(candidates, w)
+		}
+	}
+	return candidates, nil
+}
@@ -0,0 +1,99 @@
+package aozora
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndexerMorphemes(t *testing.T) {
+	ix, err := NewIndexer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := ix.Morphemes("猫を見た。")
+	want := []Morpheme{
+		{Surface: "猫", BaseForm: "猫", POS: []string{"名詞", "一般"}, Start: 0, End: 1},
+		{Surface: "を", BaseForm: "を", POS: []string{"助詞", "格助詞", "一般"}, Start: 1, End: 2},
+		{Surface: "見", BaseForm: "見る", POS: []string{"動詞", "自立"}, Start: 2, End: 3},
+		{Surface: "た", BaseForm: "た", POS: []string{"助動詞"}, Start: 3, End: 4},
+		{Surface: "。", BaseForm: "。", POS: []string{"記号", "句点"}, Start: 4, End: 5},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %+v, but got %+v", want, got)
+	}
+}
+
+func TestSQLiteStoreKWIC(t *testing.T) {
+	store := openTestStore(t)
+
+	entries := []struct {
+		entry   Entry
+		content string
+	}{
+		{
+			entry:   Entry{AuthorID: "000001", Author: "テスト 太郎", TitleID: "1", Title: "見る"},
+			content: "空を見る。\n海を見た。",
+		},
+		{
+			entry:   Entry{AuthorID: "000002", Author: "テスト 花子", TitleID: "2", Title: "見れば"},
+			content: "山を見れば、見事な眺めだ。",
+		},
+	}
+	for _, e := range entries {
+		err := store.AddEntry(&e.entry, e.content)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keywords := func(lines []Concordance) []string {
+		got := []string{}
+		for _, l := range lines {
+			got = append(got, l.TitleID+":"+l.Left+"["+l.Keyword+"]"+l.Right)
+		}
+		return got
+	}
+
+	tests := []struct {
+		word string
+		opts KWICOptions
+		want []string
+	}{
+		{word: "見る", opts: KWICOptions{Width: 2}, want: []string{"1:空を[見る]。 "}},
+		{word: "見る", opts: KWICOptions{Width: 2, Lemma: true}, want: []string{"1:空を[見る]。 ", "1:海を[見]た。", "2:山を[見れ]ば、"}},
+		{word: "見た", opts: KWICOptions{Width: 1, Lemma: true, AuthorID: "000002"}, want: []string{"2:を[見れ]ば"}},
+		{word: "を", opts: KWICOptions{Width: 1, POS: "助詞-格助詞"}, want: []string{"1:空[を]見", "1:海[を]見", "2:山[を]見"}},
+		{word: "を", opts: KWICOptions{POS: "動詞"}, want: []string{}},
+		{word: "犬", opts: KWICOptions{}, want: []string{}},
+	}
+	for _, tt := range tests {
+		lines, err := store.KWIC(tt.word, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := keywords(lines)
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("%s %+v: want %q, but got %q", tt.word, tt.opts, tt.want, got)
+		}
+	}
+
+	lines, err := store.KWIC("見れ", KWICOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Concordance{{
+		AuthorID: "000002",
+		Author:   "テスト 花子",
+		TitleID:  "2",
+		Title:    "見れば",
+		Left:     "山を",
+		Keyword:  "見れ",
+		Right:    "ば、見事な眺めだ。",
+		BaseForm: "見る",
+		POS:      "動詞-自立",
+	}}
+	if !reflect.DeepEqual(want, lines) {
+		t.Errorf("want %+v, but got %+v", want, lines)
+	}
+}
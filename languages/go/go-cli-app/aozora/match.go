@@ -0,0 +1,222 @@
+package aozora
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// oldKanji は旧字体と対応する新字体
+var oldKanji = strings.NewReplacer(
+	"亞", "亜", "惡", "悪", "壓", "圧", "圍", "囲", "爲", "為", "醫", "医", "壹", "壱", "隱", "隠",
+	"榮", "栄", "營", "営", "衞", "衛", "驛", "駅", "圓", "円", "鹽", "塩", "緣", "縁", "艷", "艶",
+	"應", "応", "歐", "欧", "毆", "殴", "櫻", "桜", "奧", "奥", "溫", "温", "價", "価", "假", "仮",
+	"畫", "画", "會", "会", "壞", "壊", "懷", "懐", "繪", "絵", "擴", "拡", "覺", "覚", "學", "学",
+	"嶽", "岳", "樂", "楽", "勸", "勧", "卷", "巻", "歡", "歓", "觀", "観", "關", "関", "陷", "陥",
+	"氣", "気", "歸", "帰", "僞", "偽", "戲", "戯", "犧", "犠", "舊", "旧", "據", "拠", "擧", "挙",
+	"峽", "峡", "挾", "挟", "狹", "狭", "曉", "暁", "區", "区", "驅", "駆", "勳", "勲", "徑", "径",
+	"惠", "恵", "溪", "渓", "經", "経", "繼", "継", "莖", "茎", "螢", "蛍", "輕", "軽", "鷄", "鶏",
+	"藝", "芸", "缺", "欠", "儉", "倹", "劍", "剣", "圈", "圏", "檢", "検", "權", "権", "獻", "献",
+	"縣", "県", "險", "険", "顯", "顕", "驗", "験", "嚴", "厳", "效", "効", "廣", "広", "恆", "恒",
+	"鑛", "鉱", "號", "号", "國", "国", "黑", "黒", "濟", "済", "碎", "砕", "齋", "斎", "劑", "剤",
+	"雜", "雑", "參", "参", "慘", "惨", "棧", "桟", "蠶", "蚕", "贊", "賛", "殘", "残", "絲", "糸",
+	"齒", "歯", "兒", "児", "辭", "辞", "濕", "湿", "實", "実", "舍", "舎", "寫", "写", "釋", "釈",
+	"壽", "寿", "收", "収", "從", "従", "澁", "渋", "獸", "獣", "縱", "縦", "肅", "粛", "處", "処",
+	"緖", "緒", "敍", "叙", "將", "将", "稱", "称", "燒", "焼", "證", "証", "奬", "奨", "條", "条",
+	"狀", "状", "乘", "乗", "淨", "浄", "剩", "剰", "疊", "畳", "孃", "嬢", "讓", "譲", "釀", "醸",
+	"觸", "触", "寢", "寝", "愼", "慎", "眞", "真", "盡", "尽", "圖", "図", "粹", "粋", "醉", "酔",
+	"隨", "随", "髓", "髄", "數", "数", "樞", "枢", "聲", "声", "靜", "静", "齊", "斉", "攝", "摂",
+	"竊", "窃", "專", "専", "戰", "戦", "淺", "浅", "潛", "潜", "纖", "繊", "錢", "銭", "禪", "禅",
+	"雙", "双", "壯", "壮", "搜", "捜", "插", "挿", "爭", "争", "總", "総", "聰", "聡", "莊", "荘",
+	"裝", "装", "騷", "騒", "藏", "蔵", "臟", "臓", "屬", "属", "續", "続", "墮", "堕", "體", "体",
+	"對", "対", "帶", "帯", "滯", "滞", "臺", "台", "瀧", "滝", "擇", "択", "澤", "沢", "單", "単",
+	"擔", "担", "膽", "胆", "團", "団", "彈", "弾", "斷", "断", "癡", "痴", "遲", "遅", "晝", "昼",
+	"蟲", "虫", "鑄", "鋳", "廳", "庁", "聽", "聴", "鎭", "鎮", "遞", "逓", "鐵", "鉄", "轉", "転",
+	"點", "点", "傳", "伝", "黨", "党", "盜", "盗", "燈", "灯", "當", "当", "鬪", "闘", "德", "徳",
+	"獨", "独", "讀", "読", "屆", "届", "繩", "縄", "貳", "弐", "腦", "脳", "霸", "覇", "廢", "廃",
+	"拜", "拝", "賣", "売", "麥", "麦", "發", "発", "髮", "髪", "拔", "抜", "蠻", "蛮", "祕", "秘",
+	"濱", "浜", "甁", "瓶", "拂", "払", "佛", "仏", "竝", "並", "變", "変", "邊", "辺", "邉", "辺",
+	"辨", "弁", "瓣", "弁", "辯", "弁", "舖", "舗", "步", "歩", "寶", "宝", "豐", "豊", "沒", "没",
+	"飜", "翻", "每", "毎", "萬", "万", "滿", "満", "默", "黙", "彌", "弥", "譯", "訳", "藥", "薬",
+	"與", "与", "豫", "予", "餘", "余", "譽", "誉", "搖", "揺", "樣", "様", "謠", "謡", "來", "来",
+	"亂", "乱", "覽", "覧", "龍", "竜", "兩", "両", "獵", "猟", "綠", "緑", "壘", "塁", "勵", "励",
+	"禮", "礼", "靈", "霊", "齡", "齢", "戀", "恋", "爐", "炉", "勞", "労", "樓", "楼", "錄", "録",
+	"灣", "湾", "鷗", "鴎", "﨑", "崎", "髙", "高",
+)
+
+// NormalizeName は作者名や作品名を表記の揺れを無視して比べるために正規化する
+//
+// 全角と半角を揃え (NFKC)、片仮名を平仮名に、旧字体を新字体に、英字を小文字にし、
+// 空白を取り除く。
+func NormalizeName(s string) string {
+	s = oldKanji.Replace(norm.NFKC.String(s))
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'ァ' && r <= 'ヶ':
+			b.WriteRune(r - 'ァ' + 'ぁ')
+		case r >= 'A' && r <= 'Z':
+			b.WriteRune(r - 'A' + 'a')
+		case r == ' ' || r == '\t':
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// AmbiguousError は名前に当てはまる作者や作品が複数ある場合に返される
+type AmbiguousError struct {
+	Kind       string   // author か work
+	Name       string   // 指定された名前
+	Candidates []string // 当てはまった作者や作品。一件ごとに ID と名前を並べる
+}
+
+func (e *AmbiguousError) Error() string {
+	return fmt.Sprintf("aozora: ambiguous %s %q: %d candidates", e.Kind, e.Name, len(e.Candidates))
+}
+
+// sameID は作者 ID や作品 ID が等しいかどうかを返す
+//
+// ID は 000879 のように 0 埋めされていることがあるので、どちらも数値なら数値として比べる。
+func sameID(id, s string) bool {
+	a, err := strconv.Atoi(id)
+	if err != nil {
+		return id == s
+	}
+	b, err := strconv.Atoi(s)
+	if err != nil {
+		return false
+	}
+	return a == b
+}
+
+// FindAuthors は作者名か読みかローマ字表記に name を含む作者を返す
+//
+// 比べる前に NormalizeName で正規化する。name が作者 ID と数値として一致する場合は
+// その作者だけを返す。
+func (s *SQLiteStore) FindAuthors(name string) ([]Author, error) {
+	authors, err := s.Authors()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range authors {
+		if sameID(a.ID, name) {
+			return []Author{a}, nil
+		}
+	}
+
+	q := NormalizeName(name)
+	found := []Author{}
+	for _, a := range authors {
+		if strings.Contains(NormalizeName(a.Name), q) ||
+			strings.Contains(NormalizeName(a.Yomi), q) ||
+			strings.Contains(NormalizeName(a.Romaji), q) {
+			found = append(found, a)
+		}
+	}
+	return found, nil
+}
+
+// ResolveAuthor は name に当てはまる作者を一人に決める
+//
+// 複数の作者が当てはまっても、正規化した作者名が name と一致するのが一人だけなら
+// その作者を返す。決められない場合は *AmbiguousError を、見つからない場合は
+// ErrNotFound を返す。
+func (s *SQLiteStore) ResolveAuthor(name string) (*Author, error) {
+	authors, err := s.FindAuthors(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(authors) > 1 {
+		exact := []Author{}
+		for _, a := range authors {
+			if NormalizeName(a.Name) == NormalizeName(name) {
+				exact = append(exact, a)
+			}
+		}
+		if len(exact) == 1 {
+			authors = exact
+		}
+	}
+	switch len(authors) {
+	case 0:
+		return nil, fmt.Errorf("%w: author %q", ErrNotFound, name)
+	case 1:
+		return &authors[0], nil
+	}
+	e := &AmbiguousError{Kind: "author", Name: name}
+	for _, a := range authors {
+		e.Candidates = append(e.Candidates, a.ID+" "+a.Name)
+	}
+	return nil, e
+}
+
+// FindWorks は作者 authorID の作品のうち、作品名に title を含むものを返す
+//
+// authorID が空の場合はすべての作者の作品から探す。title が作品 ID と数値として一致する場合は
+// その作品だけを返す。
+func (s *SQLiteStore) FindWorks(authorID, title string) ([]Entry, error) {
+	works, err := s.Works(WorkFilter{AuthorID: authorID})
+	if err != nil {
+		return nil, err
+	}
+	if authorID != "" {
+		for _, w := range works {
+			if sameID(w.TitleID, title) {
+				return []Entry{w}, nil
+			}
+		}
+	}
+
+	q := NormalizeName(title)
+	found := []Entry{}
+	for _, w := range works {
+		if strings.Contains(NormalizeName(w.Title), q) || strings.Contains(NormalizeName(w.TitleYomi), q) {
+			found = append(found, w)
+		}
+	}
+	return found, nil
+}
+
+// ResolveWork は作者名か作者 ID の author と、作品名か作品 ID の title に当てはまる作品を一つに決める
+//
+// author が空の場合はすべての作者の作品から探す。決め方は ResolveAuthor と同じ。
+func (s *SQLiteStore) ResolveWork(author, title string) (*Entry, error) {
+	authorID := ""
+	if author != "" {
+		a, err := s.ResolveAuthor(author)
+		if err != nil {
+			return nil, err
+		}
+		authorID = a.ID
+	}
+	works, err := s.FindWorks(authorID, title)
+	if err != nil {
+		return nil, err
+	}
+	if len(works) > 1 {
+		exact := []Entry{}
+		for _, w := range works {
+			if NormalizeName(w.Title) == NormalizeName(title) {
+				exact = append(exact, w)
+			}
+		}
+		if len(exact) == 1 {
+			works = exact
+		}
+	}
+	switch len(works) {
+	case 0:
+		return nil, fmt.Errorf("%w: work %q", ErrNotFound, title)
+	case 1:
+		return &works[0], nil
+	}
+	e := &AmbiguousError{Kind: "work", Name: title}
+	for _, w := range works {
+		e.Candidates = append(e.Candidates, fmt.Sprintf("%s %s %s (%s)", w.AuthorID, w.TitleID, w.Title, w.Author))
+	}
+	return nil, e
+}
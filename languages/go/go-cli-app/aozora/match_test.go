@@ -0,0 +1,135 @@
+package aozora
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "芥川 竜之介", want: "芥川竜之介"},
+		{name: "芥川龍之介", want: "芥川竜之介"},
+		{name: "アクタガワ", want: "あくたがわ"},
+		{name: "ｱｸﾀｶﾞﾜ", want: "あくたがわ"},
+		{name: "Ａｋｕｔａｇａｗａ", want: "akutagawa"},
+		{name: "森　鷗外", want: "森鴎外"},
+		{name: "國木田獨步", want: "国木田独歩"},
+	}
+	for _, tt := range tests {
+		got := NormalizeName(tt.name)
+		if got != tt.want {
+			t.Errorf("%q: want %q, but got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func openMatchTestStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+
+	store := openTestStore(t)
+	entries := []Entry{
+		{AuthorID: "000879", Author: "芥川 竜之介", AuthorYomi: "あくたがわ りゅうのすけ", TitleID: "128", Title: "羅生門"},
+		{AuthorID: "000879", Author: "芥川 竜之介", TitleID: "92", Title: "蜘蛛の糸"},
+		{AuthorID: "000880", Author: "芥川 竜之介の弟子", TitleID: "1", Title: "羅生門の後"},
+		{AuthorID: "000148", Author: "夏目 漱石", AuthorYomi: "なつめ そうせき", TitleID: "789", Title: "吾輩は猫である"},
+		{AuthorID: "000148", Author: "夏目 漱石", TitleID: "794", Title: "猫の墓"},
+	}
+	for _, entry := range entries {
+		err := store.AddEntry(&entry, "本文")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestResolveAuthor(t *testing.T) {
+	store := openMatchTestStore(t)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "000148", want: "000148"},
+		// 作者 ID は 0 埋めを省いてもよい
+		{name: "148", want: "000148"},
+		{name: "漱石", want: "000148"},
+		{name: "ソウセキ", want: "000148"},
+		// 芥川龍之介の弟子にも当てはまるが、名前が一致する方を選ぶ
+		{name: "芥川龍之介", want: "000879"},
+		{name: "弟子", want: "000880"},
+	}
+	for _, tt := range tests {
+		got, err := store.ResolveAuthor(tt.name)
+		if err != nil {
+			t.Errorf("%q: %v", tt.name, err)
+			continue
+		}
+		if got.ID != tt.want {
+			t.Errorf("%q: want %v, but got %v", tt.name, tt.want, got.ID)
+		}
+	}
+
+	_, err := store.ResolveAuthor("芥川")
+	var ae *AmbiguousError
+	if !errors.As(err, &ae) {
+		t.Fatalf("want AmbiguousError, but got %v", err)
+	}
+	want := []string{"000879 芥川 竜之介", "000880 芥川 竜之介の弟子"}
+	if !reflect.DeepEqual(want, ae.Candidates) {
+		t.Errorf("want %v, but got %v", want, ae.Candidates)
+	}
+
+	_, err = store.ResolveAuthor("太宰")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("want ErrNotFound, but got %v", err)
+	}
+}
+
+func TestResolveWork(t *testing.T) {
+	store := openMatchTestStore(t)
+
+	tests := []struct {
+		author string
+		title  string
+		want   [2]string
+	}{
+		{author: "000879", title: "128", want: [2]string{"000879", "128"}},
+		{author: "879", title: "0128", want: [2]string{"000879", "128"}},
+		{author: "芥川龍之介", title: "蜘蛛", want: [2]string{"000879", "92"}},
+		{author: "", title: "蜘蛛", want: [2]string{"000879", "92"}},
+		{author: "", title: "羅生門", want: [2]string{"000879", "128"}},
+		{author: "漱石", title: "猫で", want: [2]string{"000148", "789"}},
+	}
+	for _, tt := range tests {
+		got, err := store.ResolveWork(tt.author, tt.title)
+		if err != nil {
+			t.Errorf("%q %q: %v", tt.author, tt.title, err)
+			continue
+		}
+		if [2]string{got.AuthorID, got.TitleID} != tt.want {
+			t.Errorf("%q %q: want %v, but got %v %v", tt.author, tt.title, tt.want, got.AuthorID, got.TitleID)
+		}
+	}
+
+	_, err := store.ResolveWork("漱石", "猫")
+	var ae *AmbiguousError
+	if !errors.As(err, &ae) {
+		t.Fatalf("want AmbiguousError, but got %v", err)
+	}
+	want := []string{"000148 789 吾輩は猫である (夏目 漱石)", "000148 794 猫の墓 (夏目 漱石)"}
+	if !reflect.DeepEqual(want, ae.Candidates) {
+		t.Errorf("want %v, but got %v", want, ae.Candidates)
+	}
+
+	for _, tt := range [][2]string{{"漱石", "羅生門"}, {"太宰", "猫"}} {
+		_, err = store.ResolveWork(tt[0], tt[1])
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%v: want ErrNotFound, but got %v", tt, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package aozora
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+// smallKana は小書きの仮名と対応する大きい仮名
+var smallKana = strings.NewReplacer(
+	"ぁ", "あ", "ぃ", "い", "ぅ", "う", "ぇ", "え", "ぉ", "お",
+	"っ", "つ", "ゃ", "や", "ゅ", "ゆ", "ょ", "よ", "ゎ", "わ", "ゕ", "か", "ゖ", "け",
+)
+
+// SortKey は読みから作品を五十音順に並べるためのキーを作る
+//
+// 青空文庫のソート用読みと同じように、片仮名を平仮名に、小書きの仮名を大きい仮名に、
+// 濁音と半濁音を清音にし、仮名以外の文字を取り除く。
+func SortKey(yomi string) string {
+	var b strings.Builder
+	// 濁点と半濁点を結合文字に分けてから取り除く
+	for _, r := range norm.NFD.String(width.Widen.String(yomi)) {
+		switch {
+		case r >= 'ァ' && r <= 'ヶ':
+			b.WriteRune(r - 'ァ' + 'ぁ')
+		case unicode.Is(unicode.Hiragana, r) || r == 'ー':
+			b.WriteRune(r)
+		}
+	}
+	return smallKana.Replace(b.String())
+}
+
+var yearPat = regexp.MustCompile(`[0-9]{4}`)
+
+// FirstYear は初出の記述から西暦の年を取り出す。見つからない場合は 0 を返す
+func FirstYear(firstAppearance string) int {
+	m := yearPat.FindString(width.Narrow.String(firstAppearance))
+	if m == "" {
+		return 0
+	}
+	year, _ := strconv.Atoi(m)
+	return year
+}
@@ -0,0 +1,39 @@
+package aozora
+
+import "testing"
+
+func TestSortKey(t *testing.T) {
+	tests := []struct {
+		yomi string
+		want string
+	}{
+		{yomi: "らしょうもん", want: "らしようもん"},
+		{yomi: "がくもんのすすめ", want: "かくもんのすすめ"},
+		{yomi: "ヴィヨンのつま", want: "ういよんのつま"},
+		{yomi: "ﾃｽﾄ 001", want: "てすと"},
+		{yomi: "ぱんど・ら", want: "はんとら"},
+	}
+	for _, tt := range tests {
+		got := SortKey(tt.yomi)
+		if got != tt.want {
+			t.Errorf("%q: want %q, but got %q", tt.yomi, tt.want, got)
+		}
+	}
+}
+
+func TestFirstYear(t *testing.T) {
+	tests := []struct {
+		firstAppearance string
+		want            int
+	}{
+		{firstAppearance: "「帝国文学」1915（大正4）年11月", want: 1915},
+		{firstAppearance: "「新小説」１９２２（大正１１）年１月", want: 1922},
+		{firstAppearance: "", want: 0},
+	}
+	for _, tt := range tests {
+		got := FirstYear(tt.firstAppearance)
+		if got != tt.want {
+			t.Errorf("%q: want %d, but got %d", tt.firstAppearance, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package aozora
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrSchemaTooNew はこのプログラムより新しいバージョンで作られたデータベースを開こうとした場合に返される
+var ErrSchemaTooNew = errors.New("aozora: database schema is newer than this program")
+
+// ErrFTS5Required は FTS4 でビルドしたプログラムで FTS5 を使おうとした場合に返される
+//
+// FTS5 に移したデータベースを開いた場合と、MigrateFTS5 を呼んだ場合に返す。
+var ErrFTS5Required = errors.New("aozora: FTS5 requires building with -tags sqlite_fts5")
+
+// migration はスキーマを一つ前のバージョンから更新する
+//
+// バージョンを記録する前のデータベースには一部のテーブルや列が既にあることがあるので、
+// 既にあっても失敗しないように書く。
+type migration struct {
+	name string
+	up   func(tx *sql.Tx) error
+}
+
+// migrations はスキーマの更新の一覧。i 番目を適用するとバージョン i+1 になる
+//
+// 適用済みの更新は変更せず、スキーマを変える場合は末尾に追加する。
+// 一覧はビルドタグによらず同じにし、FTS5 への移行は MigrateFTS5 で明示的に行う。
+var migrations = []migration{
+	{"create tables", execAll(
+		`CREATE TABLE IF NOT EXISTS authors(author_id TEXT, author TEXT, PRIMARY KEY (author_id))`,
+		`CREATE TABLE IF NOT EXISTS contents(author_id TEXT, title_id TEXT, title TEXT, content TEXT, PRIMARY KEY (author_id, title_id))`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS contents_fts USING fts4(words)`,
+	)},
+	{"add images", execAll(
+		`CREATE TABLE IF NOT EXISTS images(author_id TEXT, title_id TEXT, name TEXT, data BLOB, PRIMARY KEY (author_id, title_id, name))`,
+	)},
+	{"add fetches", execAll(
+		`CREATE TABLE IF NOT EXISTS fetches(author_id TEXT, title_id TEXT, zip_url TEXT, etag TEXT, last_modified TEXT, hash TEXT, PRIMARY KEY (author_id, title_id))`,
+	)},
+	{"add crawl", execAll(
+		`CREATE TABLE IF NOT EXISTS crawl(seq INTEGER, author_id TEXT, title_id TEXT, entry TEXT, status TEXT, error TEXT, PRIMARY KEY (author_id, title_id))`,
+	)},
+	{"add metadata columns", addColumns(
+		column{"authors", "author_yomi", "TEXT NOT NULL DEFAULT ''"},
+		column{"authors", "author_romaji", "TEXT NOT NULL DEFAULT ''"},
+		column{"authors", "birth_date", "TEXT NOT NULL DEFAULT ''"},
+		column{"authors", "death_date", "TEXT NOT NULL DEFAULT ''"},
+		column{"contents", "title_yomi", "TEXT NOT NULL DEFAULT ''"},
+		column{"contents", "title_sort", "TEXT NOT NULL DEFAULT ''"},
+		column{"contents", "subtitle", "TEXT NOT NULL DEFAULT ''"},
+		column{"contents", "original_title", "TEXT NOT NULL DEFAULT ''"},
+		column{"contents", "first_appearance", "TEXT NOT NULL DEFAULT ''"},
+		column{"contents", "first_year", "INTEGER NOT NULL DEFAULT 0"},
+		column{"contents", "char_type", "TEXT NOT NULL DEFAULT ''"},
+		column{"contents", "translator", "TEXT NOT NULL DEFAULT ''"},
+		column{"contents", "copyright", "INTEGER NOT NULL DEFAULT 0"},
+		column{"contents", "release_date", "TEXT NOT NULL DEFAULT ''"},
+		column{"contents", "site_url", "TEXT NOT NULL DEFAULT ''"},
+		column{"contents", "zip_url", "TEXT NOT NULL DEFAULT ''"},
+	)},
+	// FTS5 でビルドした場合だけ FTS5 に移していたバージョン。FTS4 と FTS5 で
+	// バージョンの意味が変わらないように、今は何もせず番号だけを残す
+	{"reserved for fts5", execAll()},
+}
+
+// SchemaVersion はこのプログラムが扱うスキーマのバージョン
+var SchemaVersion = len(migrations)
+
+// execAll は queries を順に実行する更新を作る
+func execAll(queries ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, query := range queries {
+			_, err := tx.Exec(query)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// column は追加する列
+type column struct {
+	table string
+	name  string
+	decl  string
+}
+
+// addColumns は columns のうちテーブルに無い列を追加する更新を作る
+func addColumns(columns ...column) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, c := range columns {
+			var n int
+			err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, c.table, c.name).Scan(&n)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				continue
+			}
+			_, err = tx.Exec(`ALTER TABLE ` + c.table + ` ADD COLUMN ` + c.name + ` ` + c.decl)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Version はデータベースのスキーマのバージョンを返す
+//
+// バージョンは PRAGMA user_version に記録する。記録する前のデータベースは 0 になる。
+func Version(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+// Migrate はデータベースのスキーマを SchemaVersion まで更新する
+//
+// 更新は一つずつトランザクションで適用し、そのたびにバージョンを記録する。
+// データベースの方が新しい場合は ErrSchemaTooNew を返し、何も変更しない。
+func Migrate(db *sql.DB) error {
+	version, err := Version(db)
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("%w: version %d, but this program supports up to %d", ErrSchemaTooNew, version, SchemaVersion)
+	}
+
+	for i := version; i < SchemaVersion; i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		err = migrations[i].up(tx)
+		if err == nil {
+			// PRAGMA にはプレースホルダを使えない
+			_, err = tx.Exec(`PRAGMA user_version = ` + strconv.Itoa(i+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", i+1, migrations[i].name, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package aozora
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.sqlite")
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := Version(store.DB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != SchemaVersion {
+		t.Errorf("want version %d, but got %d", SchemaVersion, version)
+	}
+	module, err := tableFTSModule(store.DB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// FTS5 でビルドしても、開いただけでは FTS5 に移さない
+	if module != "fts4" {
+		t.Errorf("want fts4, but got %s", module)
+	}
+	if !FTS5 {
+		err = store.MigrateFTS5()
+		if !errors.Is(err, ErrFTS5Required) {
+			t.Errorf("want ErrFTS5Required, but got %v", err)
+		}
+	}
+	entry := Entry{AuthorID: "999999", Author: "テスト 太郎", TitleID: "001", Title: "テスト書籍001"}
+	err = store.AddEntry(&entry, "本文")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// 開き直しても更新を適用し直さず、作品も残っている
+	store, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	titles, err := store.Titles("999999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(titles) != 1 {
+		t.Errorf("want 1 title, but got %+v", titles)
+	}
+}
+
+func TestMigrateTooNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.sqlite")
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`PRAGMA user_version = 1000`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Open(path)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("want ErrSchemaTooNew, but got %v", err)
+	}
+
+	var n int
+	err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master`).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("want no tables created, but got %d", n)
+	}
+}
+
+func TestMigrateRollback(t *testing.T) {
+	saved := migrations
+	defer func() {
+		migrations = saved
+		SchemaVersion = len(migrations)
+	}()
+	migrations = append(migrations[:len(migrations):len(migrations)], migration{"broken", execAll(
+		`CREATE TABLE broken(id INTEGER)`,
+		`INSERT INTO missing VALUES(1)`,
+	)})
+	SchemaVersion = len(migrations)
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "database.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = Migrate(db)
+	if err == nil {
+		t.Fatal("want error, but got nil")
+	}
+
+	// 失敗した更新は取り消され、それまでの更新だけが記録される
+	version, err := Version(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(saved) {
+		t.Errorf("want version %d, but got %d", len(saved), version)
+	}
+	var n int
+	err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'broken'`).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Error("want broken table rolled back")
+	}
+}
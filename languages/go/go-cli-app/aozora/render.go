@@ -0,0 +1,164 @@
+package aozora
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// 本文の出力形式
+const (
+	FormatPlain     = "plain"      // ルビと注記を取り除く
+	FormatRubyParen = "ruby-paren" // ルビを括弧に入れて本文の後ろに置く
+	FormatHTML      = "html"       // ルビを <ruby> に、見出しと傍点を HTML の要素にする
+)
+
+// Formats は Render が受け付ける出力形式の一覧
+var Formats = []string{FormatPlain, FormatRubyParen, FormatHTML}
+
+// Render は本文を format の形式で返す
+func (t *Text) Render(format string) (string, error) {
+	switch format {
+	case FormatPlain:
+		return t.Plain(), nil
+	case FormatRubyParen:
+		return t.RubyParen(), nil
+	case FormatHTML:
+		return t.HTML(), nil
+	}
+	return "", fmt.Errorf("unknown format: %q", format)
+}
+
+// RubyParen はルビを 漢字（かんじ） の形にした本文を返す
+func (t *Text) RubyParen() string {
+	var b strings.Builder
+	for _, seg := range t.Body {
+		b.WriteString(seg.Text)
+		if seg.Ruby != "" {
+			b.WriteString("（" + seg.Ruby + "）")
+		}
+	}
+	return b.String()
+}
+
+// htmlTags は注記に対応する HTML の開始タグと終了タグ
+var htmlTags = map[string][2]string{
+	"大見出し":  {`<h3 class="o-midashi">`, `</h3>`},
+	"中見出し":  {`<h4 class="naka-midashi">`, `</h4>`},
+	"小見出し":  {`<h5 class="ko-midashi">`, `</h5>`},
+	"傍点":    {`<em class="sesame_dot">`, `</em>`},
+	"白ゴマ傍点": {`<em class="white_sesame_dot">`, `</em>`},
+	"丸傍点":   {`<em class="black_circle">`, `</em>`},
+	"白丸傍点":  {`<em class="white_circle">`, `</em>`},
+	"傍線":    {`<em class="underline_solid">`, `</em>`},
+	"太字":    {`<em class="futoji">`, `</em>`},
+}
+
+var (
+	// 「対象」は見出し、「対象」に傍点 のように直前の文字列を指す注記
+	targetNotePat = regexp.MustCompile(`^「(.+)」(?:は|に)(.+)$`)
+	// 見出し ... 見出し終わり のように範囲を指す注記
+	rangeEndPat = regexp.MustCompile(`^(.+)終わり$`)
+)
+
+// htmlUnit は HTML にした本文の一文字分、またはルビの振られた文字列一つ分
+type htmlUnit struct {
+	text string // 注記の対象を探すための本文
+	html string
+}
+
+// HTML は本文を HTML の断片にして返す
+//
+// ルビは <ruby><rb>…</rb><rt>…</rt></ruby> に、見出しと傍点の注記は対応する
+// 要素に、改行は <br /> にする。その他の注記は取り除く。
+func (t *Text) HTML() string {
+	units := []htmlUnit{}
+	// 範囲を指す注記の開始位置
+	open := map[string][]int{}
+
+	for _, seg := range t.Body {
+		switch {
+		case seg.Note != "":
+			if m := targetNotePat.FindStringSubmatch(seg.Note); m != nil {
+				tag, ok := htmlTags[m[2]]
+				if ok {
+					units = wrapTarget(units, m[1], tag)
+				}
+				continue
+			}
+			// ［＃ここから傍点］…［＃ここで傍点終わり］ も同じ範囲の注記として扱う
+			note := strings.TrimPrefix(strings.TrimPrefix(seg.Note, "ここから"), "ここで")
+			if m := rangeEndPat.FindStringSubmatch(note); m != nil {
+				tag, ok := htmlTags[m[1]]
+				starts := open[m[1]]
+				if ok && len(starts) > 0 {
+					start := starts[len(starts)-1]
+					open[m[1]] = starts[:len(starts)-1]
+					units = wrapUnits(units, start, tag)
+				}
+				continue
+			}
+			if _, ok := htmlTags[note]; ok {
+				open[note] = append(open[note], len(units))
+			}
+		case seg.Gaiji != nil:
+			units = append(units, htmlUnit{
+				text: seg.Text,
+				html: `<span class="gaiji" title="` + html.EscapeString(seg.Gaiji.Note) + `">` + html.EscapeString(seg.Text) + `</span>`,
+			})
+		case seg.Ruby != "":
+			units = append(units, htmlUnit{
+				text: seg.Text,
+				html: "<ruby><rb>" + html.EscapeString(seg.Text) + "</rb><rt>" + html.EscapeString(seg.Ruby) + "</rt></ruby>",
+			})
+		default:
+			for _, r := range seg.Text {
+				s := string(r)
+				if r == '\n' {
+					units = append(units, htmlUnit{text: s, html: "<br />\n"})
+					continue
+				}
+				units = append(units, htmlUnit{text: s, html: html.EscapeString(s)})
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, u := range units {
+		b.WriteString(u.html)
+	}
+	return b.String()
+}
+
+// wrapTarget は units の末尾が target になっていれば、その部分を tag で囲む
+func wrapTarget(units []htmlUnit, target string, tag [2]string) []htmlUnit {
+	text := ""
+	for i := len(units) - 1; i >= 0; i-- {
+		text = units[i].text + text
+		if len(text) < len(target) {
+			continue
+		}
+		if text == target {
+			return wrapUnits(units, i, tag)
+		}
+		break
+	}
+	return units
+}
+
+// wrapUnits は units[start:] を一つにまとめて tag で囲む
+func wrapUnits(units []htmlUnit, start int, tag [2]string) []htmlUnit {
+	if start >= len(units) {
+		return units
+	}
+	var text, h strings.Builder
+	for _, u := range units[start:] {
+		text.WriteString(u.text)
+		h.WriteString(u.html)
+	}
+	return append(units[:start], htmlUnit{
+		text: text.String(),
+		html: tag[0] + h.String() + tag[1],
+	})
+}
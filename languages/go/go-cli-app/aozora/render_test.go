@@ -0,0 +1,74 @@
+package aozora
+
+import "testing"
+
+func TestTextRender(t *testing.T) {
+	text := ParseText("一［＃「一」は中見出し］\n" +
+		"一人の下人《げにん》が<待って>いた［＃「いた」に傍点］。\n" +
+		"［＃大見出し］羅生門《らしょうもん》［＃大見出し終わり］［＃地から２字上げ］")
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: FormatPlain,
+			want:   "一\n一人の下人が<待って>いた。\n羅生門",
+		},
+		{
+			format: FormatRubyParen,
+			want:   "一\n一人の下人（げにん）が<待って>いた。\n羅生門（らしょうもん）",
+		},
+		{
+			format: FormatHTML,
+			want: `<h4 class="naka-midashi">一</h4><br />` + "\n" +
+				`一人の<ruby><rb>下人</rb><rt>げにん</rt></ruby>が&lt;待って&gt;<em class="sesame_dot">いた</em>。<br />` + "\n" +
+				`<h3 class="o-midashi"><ruby><rb>羅生門</rb><rt>らしょうもん</rt></ruby></h3>`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := text.Render(tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: want %q, but got %q", tt.format, tt.want, got)
+		}
+	}
+
+	_, err := text.Render("markdown")
+	if err == nil {
+		t.Error("want error for unknown format")
+	}
+}
+
+func TestTextHTMLRange(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{
+			text: "［＃中見出し］序章［＃中見出し終わり］",
+			want: `<h4 class="naka-midashi">序章</h4>`,
+		},
+		{
+			text: "［＃ここから中見出し］序章［＃ここで中見出し終わり］",
+			want: `<h4 class="naka-midashi">序章</h4>`,
+		},
+		{
+			text: "ある［＃ここから傍点］日の事［＃ここで傍点終わり］でございます",
+			want: `ある<em class="sesame_dot">日の事</em>でございます`,
+		},
+		{
+			// 対応する開始の無い終わりの注記は取り除くだけにする
+			text: "序章［＃ここで中見出し終わり］",
+			want: `序章`,
+		},
+	}
+	for _, tt := range tests {
+		got := ParseText(tt.text).HTML()
+		if got != tt.want {
+			t.Errorf("%q: want %q, but got %q", tt.text, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,676 @@
+package aozora
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteStore は SQLite を使った Store の実装
+type SQLiteStore struct {
+	db      *sql.DB
+	indexer *Indexer
+	fts     *ftsDialect // 全文検索のインデックスのモジュールに合った書き方
+}
+
+var _ Store = (*SQLiteStore)(nil)
+
+// Open は dsn の SQLite データベースを開き、スキーマを最新のバージョンに更新する
+func Open(dsn string) (*SQLiteStore, error) {
+	ix, err := NewIndexer()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := NewSQLiteStore(db, ix)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewSQLiteStore は開いたデータベースのスキーマを更新し、SQLiteStore を返す
+//
+// このプログラムより新しいスキーマのデータベースは ErrSchemaTooNew を返して開かない。
+// FTS4 でビルドした場合、FTS5 に移したデータベースは ErrFTS5Required を返して開かない。
+// AddEntry は本文の分かち書きに ix を使う。
+func NewSQLiteStore(db *sql.DB, ix *Indexer) (*SQLiteStore, error) {
+	err := Migrate(db)
+	if err != nil {
+		return nil, err
+	}
+	fts, err := openFTS(db)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteStore{db: db, indexer: ix, fts: fts}, nil
+}
+
+// Indexer は AddEntry が使う Indexer を返す
+func (s *SQLiteStore) Indexer() *Indexer {
+	return s.indexer
+}
+
+// DB は内部で使っている *sql.DB を返す
+func (s *SQLiteStore) DB() *sql.DB {
+	return s.db
+}
+
+// Close はデータベースを閉じる
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) AddEntry(entry *Entry, content string) error {
+	return s.AddDocument(s.indexer.Document(entry, content))
+}
+
+// AddDocument は分かち書き済みの作品を一つのトランザクションで保存する
+func (s *SQLiteStore) AddDocument(doc *Document) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = addDocument(tx, doc)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// addDocument は tx の中で作品を保存する
+//
+// contents の rowid を全文検索の rowid に使うので、作品を上書きしても rowid が
+// 変わらないように REPLACE ではなく UPSERT を使い、古い全文検索の行は削除する。
+// 作品名の読みからソート用の読みを、初出から年を求めて一緒に保存する。
+func addDocument(tx *sql.Tx, doc *Document) error {
+	entry := doc.Entry
+	// 作品によっては作者の詳細な情報が無いので、空の値では上書きしない
+	_, err := tx.Exec(`
+		INSERT INTO authors(author_id, author, author_yomi, author_romaji, birth_date, death_date) values(?, ?, ?, ?, ?, ?)
+		ON CONFLICT(author_id) DO UPDATE SET
+			author=excluded.author,
+			author_yomi=COALESCE(NULLIF(excluded.author_yomi, ''), author_yomi),
+			author_romaji=COALESCE(NULLIF(excluded.author_romaji, ''), author_romaji),
+			birth_date=COALESCE(NULLIF(excluded.birth_date, ''), birth_date),
+			death_date=COALESCE(NULLIF(excluded.death_date, ''), death_date)
+	`,
+		entry.AuthorID,
+		entry.Author,
+		entry.AuthorYomi,
+		entry.AuthorRomaji,
+		entry.BirthDate,
+		entry.DeathDate,
+	)
+	if err != nil {
+		return err
+	}
+
+	titleSort := entry.TitleSort
+	if titleSort == "" {
+		titleSort = SortKey(entry.TitleYomi)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO contents(
+			author_id, title_id, title, content,
+			title_yomi, title_sort, subtitle, original_title, first_appearance, first_year,
+			char_type, translator, copyright, release_date, site_url, zip_url
+		) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(author_id, title_id) DO UPDATE SET
+			title=excluded.title,
+			content=excluded.content,
+			title_yomi=excluded.title_yomi,
+			title_sort=excluded.title_sort,
+			subtitle=excluded.subtitle,
+			original_title=excluded.original_title,
+			first_appearance=excluded.first_appearance,
+			first_year=excluded.first_year,
+			char_type=excluded.char_type,
+			translator=excluded.translator,
+			copyright=excluded.copyright,
+			release_date=excluded.release_date,
+			site_url=excluded.site_url,
+			zip_url=excluded.zip_url
+	`,
+		entry.AuthorID,
+		entry.TitleID,
+		entry.Title,
+		doc.Content,
+		entry.TitleYomi,
+		titleSort,
+		entry.Subtitle,
+		entry.OriginalTitle,
+		entry.FirstAppearance,
+		FirstYear(entry.FirstAppearance),
+		entry.CharType,
+		entry.Translator,
+		entry.Copyright,
+		entry.ReleaseDate,
+		entry.SiteURL,
+		entry.ZipURL,
+	)
+	if err != nil {
+		return err
+	}
+
+	var docID int64
+	err = tx.QueryRow(`
+		SELECT rowid FROM contents WHERE author_id = ? AND title_id = ?
+	`,
+		entry.AuthorID,
+		entry.TitleID,
+	).Scan(&docID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM contents_fts WHERE rowid = ?
+	`,
+		docID,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO contents_fts(rowid, words) values(?, ?)
+	`,
+		docID,
+		strings.Join(doc.Words, " "),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM images WHERE author_id = ? AND title_id = ?
+	`,
+		entry.AuthorID,
+		entry.TitleID,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, image := range doc.Images {
+		_, err = tx.Exec(`
+			INSERT INTO images(author_id, title_id, name, data) values(?, ?, ?, ?)
+		`,
+			entry.AuthorID,
+			entry.TitleID,
+			image.Name,
+			image.Data,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if doc.Fetch != nil {
+		return saveFetchState(tx, doc.Fetch)
+	}
+	return nil
+}
+
+// saveFetchState は tx の中で作品の検証子とハッシュを保存する
+func saveFetchState(tx *sql.Tx, state *FetchState) error {
+	_, err := tx.Exec(`
+		REPLACE INTO fetches(author_id, title_id, zip_url, etag, last_modified, hash) values(?, ?, ?, ?, ?, ?)
+	`,
+		state.AuthorID,
+		state.TitleID,
+		state.ZipURL,
+		state.ETag,
+		state.LastModified,
+		state.Hash,
+	)
+	return err
+}
+
+// SaveFetchState は作品の検証子とハッシュだけを保存する
+//
+// 本文が変わっていない作品の検証子を更新するのに使う。
+func (s *SQLiteStore) SaveFetchState(state *FetchState) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = saveFetchState(tx, state)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// FetchStates は保存されている全ての作品の検証子とハッシュを返す
+func (s *SQLiteStore) FetchStates() ([]*FetchState, error) {
+	rows, err := s.db.Query(`
+		SELECT
+			f.author_id,
+			f.title_id,
+			f.zip_url,
+			f.etag,
+			f.last_modified,
+			f.hash
+		FROM
+			fetches f
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := []*FetchState{}
+	for rows.Next() {
+		var state FetchState
+		err = rows.Scan(&state.AuthorID, &state.TitleID, &state.ZipURL, &state.ETag, &state.LastModified, &state.Hash)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, &state)
+	}
+	return states, rows.Err()
+}
+
+// Reindex は contents の本文から全文検索のインデックスを作り直す
+//
+// 以前のバージョンで作ったデータベースや、孤立した行が残っている
+// contents_fts を修復するのに使う。
+func (s *SQLiteStore) Reindex() error {
+	rows, err := s.db.Query(`SELECT rowid FROM contents`)
+	if err != nil {
+		return err
+	}
+	docIDs := []int64{}
+	for rows.Next() {
+		var docID int64
+		err = rows.Scan(&docID)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		docIDs = append(docIDs, docID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM contents_fts`)
+	if err != nil {
+		return err
+	}
+
+	for _, docID := range docIDs {
+		var content string
+		err = tx.QueryRow(`SELECT content FROM contents WHERE rowid = ?`, docID).Scan(&content)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO contents_fts(rowid, words) values(?, ?)
+		`,
+			docID,
+			strings.Join(s.indexer.Document(nil, content).Words, " "),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Authors() ([]Author, error) {
+	rows, err := s.db.Query(`
+		SELECT
+			a.author_id,
+			a.author,
+			a.author_yomi,
+			a.author_romaji,
+			a.birth_date,
+			a.death_date
+		FROM
+			authors a
+		ORDER BY
+			CAST(a.author_id AS INTEGER)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	authors := []Author{}
+	for rows.Next() {
+		var author Author
+		err = rows.Scan(&author.ID, &author.Name, &author.Yomi, &author.Romaji, &author.BirthDate, &author.DeathDate)
+		if err != nil {
+			return nil, err
+		}
+		authors = append(authors, author)
+	}
+	return authors, rows.Err()
+}
+
+func (s *SQLiteStore) Titles(authorID string) ([]Title, error) {
+	rows, err := s.db.Query(`
+		SELECT
+			c.author_id,
+			c.title_id,
+			c.title
+		FROM
+			contents c
+		WHERE
+			c.author_id = ?
+		ORDER BY
+			CAST(c.title_id AS INTEGER)
+	`, authorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := []Title{}
+	for rows.Next() {
+		var title Title
+		err = rows.Scan(&title.AuthorID, &title.ID, &title.Title)
+		if err != nil {
+			return nil, err
+		}
+		titles = append(titles, title)
+	}
+	return titles, rows.Err()
+}
+
+func (s *SQLiteStore) Content(authorID, titleID string) (string, error) {
+	var content string
+	err := s.db.QueryRow(`
+		SELECT
+			c.content
+		FROM
+			contents c
+		WHERE
+			c.author_id = ?
+			AND c.title_id = ?
+	`, authorID, titleID).Scan(&content)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return content, nil
+}
+
+// workColumns は Works と Work で作品の詳細な情報を読み出す列
+const workColumns = `
+			c.author_id,
+			a.author,
+			c.title_id,
+			c.title,
+			c.site_url,
+			c.zip_url,
+			a.author_yomi,
+			a.author_romaji,
+			a.birth_date,
+			a.death_date,
+			c.title_yomi,
+			c.title_sort,
+			c.subtitle,
+			c.original_title,
+			c.first_appearance,
+			c.char_type,
+			c.translator,
+			c.copyright,
+			c.release_date`
+
+// workSortOrders は並べ方ごとの ORDER BY 句
+var workSortOrders = map[string]string{
+	SortByID:      `CAST(c.author_id AS INTEGER), CAST(c.title_id AS INTEGER)`,
+	SortByTitle:   `c.title_sort, c.title, CAST(c.title_id AS INTEGER)`,
+	SortByYear:    `c.first_year = 0, c.first_year, CAST(c.title_id AS INTEGER)`,
+	SortByRelease: `c.release_date = '', c.release_date, CAST(c.title_id AS INTEGER)`,
+}
+
+func scanWork(rows interface{ Scan(...any) error }) (Entry, error) {
+	var e Entry
+	err := rows.Scan(
+		&e.AuthorID, &e.Author, &e.TitleID, &e.Title, &e.SiteURL, &e.ZipURL,
+		&e.AuthorYomi, &e.AuthorRomaji, &e.BirthDate, &e.DeathDate,
+		&e.TitleYomi, &e.TitleSort, &e.Subtitle, &e.OriginalTitle, &e.FirstAppearance,
+		&e.CharType, &e.Translator, &e.Copyright, &e.ReleaseDate,
+	)
+	return e, err
+}
+
+// Works は filter の条件に合う作品の詳細な情報の一覧を返す
+func (s *SQLiteStore) Works(filter WorkFilter) ([]Entry, error) {
+	sort := filter.Sort
+	if sort == "" {
+		sort = SortByID
+	}
+	order, ok := workSortOrders[sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort order: %q", filter.Sort)
+	}
+
+	where := []string{"1 = 1"}
+	args := []any{}
+	if filter.AuthorID != "" {
+		where = append(where, "c.author_id = ?")
+		args = append(args, filter.AuthorID)
+	}
+	if filter.CharType != "" {
+		where = append(where, "c.char_type = ?")
+		args = append(args, filter.CharType)
+	}
+	if filter.Translated {
+		where = append(where, "c.translator <> ''")
+	}
+	if filter.FromYear > 0 {
+		where = append(where, "c.first_year >= ?")
+		args = append(args, filter.FromYear)
+	}
+	if filter.ToYear > 0 {
+		where = append(where, "c.first_year BETWEEN 1 AND ?")
+		args = append(args, filter.ToYear)
+	}
+	if filter.Copyright != nil {
+		where = append(where, "c.copyright = ?")
+		args = append(args, *filter.Copyright)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT`+workColumns+`
+		FROM
+			contents c
+		INNER JOIN authors a
+			ON a.author_id = c.author_id
+		WHERE
+			`+strings.Join(where, " AND ")+`
+		ORDER BY
+			`+order, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	works := []Entry{}
+	for rows.Next() {
+		work, err := scanWork(rows)
+		if err != nil {
+			return nil, err
+		}
+		works = append(works, work)
+	}
+	return works, rows.Err()
+}
+
+// Work は作品の詳細な情報を返す。見つからない場合は ErrNotFound を返す
+func (s *SQLiteStore) Work(authorID, titleID string) (*Entry, error) {
+	work, err := scanWork(s.db.QueryRow(`
+		SELECT`+workColumns+`
+		FROM
+			contents c
+		INNER JOIN authors a
+			ON a.author_id = c.author_id
+		WHERE
+			c.author_id = ?
+			AND c.title_id = ?
+	`, authorID, titleID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &work, nil
+}
+
+// Images は作品と一緒に保存した挿絵を返す
+func (s *SQLiteStore) Images(authorID, titleID string) ([]Image, error) {
+	rows, err := s.db.Query(`
+		SELECT
+			i.name,
+			i.data
+		FROM
+			images i
+		WHERE
+			i.author_id = ?
+			AND i.title_id = ?
+		ORDER BY
+			i.name
+	`, authorID, titleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []Image{}
+	for rows.Next() {
+		var image Image
+		err = rows.Scan(&image.Name, &image.Data)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, rows.Err()
+}
+
+// snippetTokens は抜粋に含める語の数
+const snippetTokens = 24
+
+// 抜粋の中でマッチした語を一時的に囲む文字。分かち書きの空白を取り除いてから置き換える
+const (
+	snippetOpen  = "\x01"
+	snippetClose = "\x02"
+)
+
+// Search は query の検索語を分かち書きしてから全文検索する
+func (s *SQLiteStore) Search(query string) ([]Hit, error) {
+	return s.SearchWith(query, SearchOptions{})
+}
+
+// SearchWith は Search と同じように全文検索し、opts の範囲の結果を抜粋と一緒に返す
+//
+// インデックスを FTS5 に移した場合は bm25 で関連の高い順に、FTS4 の場合は作品 ID の順に並べる。
+func (s *SQLiteStore) SearchWith(query string, opts SearchOptions) ([]Hit, error) {
+	query = s.fts.query(s.indexer.Query(query))
+	if opts.Open == "" {
+		opts.Open = "【"
+	}
+	if opts.Close == "" {
+		opts.Close = "】"
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		// SQLite では負の LIMIT は上限なしになる
+		limit = -1
+	}
+	rows, err := s.db.Query(`
+		SELECT
+			a.author_id,
+			a.author,
+			c.title_id,
+			c.title,
+			`+s.fts.snippet+`
+		FROM
+			contents_fts
+		INNER JOIN contents c
+			ON c.rowid = contents_fts.rowid
+		INNER JOIN authors a
+			ON a.author_id = c.author_id
+		WHERE
+			contents_fts.words MATCH ?
+		ORDER BY
+			`+s.fts.rank+`
+		LIMIT ? OFFSET ?
+	`, snippetOpen, snippetClose, snippetTokens, query, limit, opts.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []Hit{}
+	for rows.Next() {
+		var hit Hit
+		err = rows.Scan(&hit.AuthorID, &hit.Author, &hit.TitleID, &hit.Title, &hit.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		hit.Snippet = strings.NewReplacer(snippetOpen, opts.Open, snippetClose, opts.Close).Replace(joinWords(hit.Snippet))
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+// joinWords は分かち書きした文字列から語の間の空白を取り除く
+//
+// 英数字の語の間の空白は元の本文にもあったものとして残す。
+func joinWords(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if r == ' ' && !(isASCIIWord(neighbor(runes, i, -1)) && isASCIIWord(neighbor(runes, i, 1))) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// neighbor は runes[i] から step の向きにある、マッチした語の印と空白以外の文字を返す
+func neighbor(runes []rune, i, step int) rune {
+	for i += step; i >= 0 && i < len(runes); i += step {
+		switch string(runes[i]) {
+		case snippetOpen, snippetClose, " ":
+		default:
+			return runes[i]
+		}
+	}
+	return 0
+}
+
+func isASCIIWord(r rune) bool {
+	return r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z'
+}
@@ -0,0 +1,441 @@
+package aozora
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openTestStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+
+	store, err := Open(filepath.Join(t.TempDir(), "database.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+	})
+	return store
+}
+
+func TestSQLiteStore(t *testing.T) {
+	store := openTestStore(t)
+
+	entries := []Entry{
+		{AuthorID: "999999", Author: "テスト 太郎", TitleID: "002", Title: "テスト書籍002"},
+		{AuthorID: "999999", Author: "テスト 太郎", TitleID: "001", Title: "テスト書籍001"},
+	}
+	contents := []string{"hello world", "goodbye world"}
+	for i := range entries {
+		err := store.AddEntry(&entries[i], contents[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	authors, err := store.Authors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAuthors := []Author{{ID: "999999", Name: "テスト 太郎"}}
+	if !reflect.DeepEqual(wantAuthors, authors) {
+		t.Errorf("want %+v, but got %+v", wantAuthors, authors)
+	}
+
+	titles, err := store.Titles("999999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTitles := []Title{
+		{AuthorID: "999999", ID: "001", Title: "テスト書籍001"},
+		{AuthorID: "999999", ID: "002", Title: "テスト書籍002"},
+	}
+	if !reflect.DeepEqual(wantTitles, titles) {
+		t.Errorf("want %+v, but got %+v", wantTitles, titles)
+	}
+
+	content, err := store.Content("999999", "002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != "hello world" {
+		t.Errorf("want %q, but got %q", "hello world", content)
+	}
+
+	_, err = store.Content("999999", "003")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("want ErrNotFound, but got %v", err)
+	}
+
+	hits, err := store.Search("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHits := []Hit{{AuthorID: "999999", Author: "テスト 太郎", TitleID: "002", Title: "テスト書籍002", Snippet: "【hello】 world"}}
+	if !reflect.DeepEqual(wantHits, hits) {
+		t.Errorf("want %+v, but got %+v", wantHits, hits)
+	}
+}
+
+func countRows(t *testing.T, store *SQLiteStore, table string) int {
+	t.Helper()
+
+	var n int
+	err := store.DB().QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSQLiteStoreReplaceEntry(t *testing.T) {
+	store := openTestStore(t)
+
+	entry := Entry{AuthorID: "999999", Author: "テスト 太郎", TitleID: "001", Title: "テスト書籍001"}
+	for _, content := range []string{"hello world", "goodbye world"} {
+		err := store.AddEntry(&entry, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := countRows(t, store, "contents_fts"); n != 1 {
+		t.Errorf("want 1 row in contents_fts, but got %d", n)
+	}
+
+	hits, err := store.Search("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 0 {
+		t.Errorf("want no hits for old content, but got %+v", hits)
+	}
+
+	hits, err = store.Search("goodbye")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 {
+		t.Errorf("want 1 hit, but got %+v", hits)
+	}
+}
+
+func TestSQLiteStoreSearchJapanese(t *testing.T) {
+	store := openTestStore(t)
+
+	entry := Entry{AuthorID: "999999", Author: "テスト 太郎", TitleID: "001", Title: "テスト書籍001"}
+	err := store.AddEntry(&entry, "吾輩は猫である。名前はまだ無い。")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{"猫", "猫 AND 名前", "吾輩は猫", "犬 OR 名前"} {
+		hits, err := store.Search(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hits) != 1 {
+			t.Errorf("%q: want 1 hit, but got %+v", query, hits)
+		}
+	}
+
+	hits, err := store.Search("猫 AND 犬")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 0 {
+		t.Errorf("want no hits, but got %+v", hits)
+	}
+}
+
+func TestSQLiteStoreSearchWith(t *testing.T) {
+	store := openTestStore(t)
+
+	contents := []string{
+		"吾輩は猫である。名前はまだ無い。",
+		"猫と猫と猫が庭で遊んでいる。",
+		"犬が歩いている。",
+		"猫が一匹いる。",
+	}
+	for i, content := range contents {
+		entry := Entry{AuthorID: "999999", Author: "テスト 太郎", TitleID: fmt.Sprintf("%03d", i+1), Title: "テスト書籍"}
+		err := store.AddEntry(&entry, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if FTS5 {
+		err := store.MigrateFTS5()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hits, err := store.SearchWith("猫", SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	titleIDs := []string{}
+	for _, hit := range hits {
+		titleIDs = append(titleIDs, hit.TitleID)
+	}
+	// FTS5 では猫が多く出てくる作品や短い作品ほど先になる
+	wantTitleIDs := []string{"001", "002", "004"}
+	if FTS5 {
+		wantTitleIDs = []string{"002", "004", "001"}
+	}
+	if !reflect.DeepEqual(wantTitleIDs, titleIDs) {
+		t.Errorf("want %v, but got %v", wantTitleIDs, titleIDs)
+	}
+
+	tests := []struct {
+		opts SearchOptions
+		want []string
+	}{
+		{opts: SearchOptions{Limit: 2}, want: wantTitleIDs[:2]},
+		{opts: SearchOptions{Limit: 2, Offset: 2}, want: wantTitleIDs[2:]},
+		{opts: SearchOptions{Offset: 1}, want: wantTitleIDs[1:]},
+		{opts: SearchOptions{Offset: 3}, want: []string{}},
+	}
+	for _, test := range tests {
+		hits, err := store.SearchWith("猫", test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, hit := range hits {
+			got = append(got, hit.TitleID)
+		}
+		if !reflect.DeepEqual(test.want, got) {
+			t.Errorf("%+v: want %v, but got %v", test.opts, test.want, got)
+		}
+	}
+
+	hits, err = store.SearchWith("名前", SearchOptions{Open: "<b>", Close: "</b>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSnippet := "吾輩は猫である。<b>名前</b>はまだ無い。"
+	if len(hits) != 1 || hits[0].Snippet != wantSnippet {
+		t.Errorf("want snippet %q, but got %+v", wantSnippet, hits)
+	}
+
+	hits, err = store.Search("吾輩 NEAR/3 猫")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 {
+		t.Errorf("want 1 hit, but got %+v", hits)
+	}
+}
+
+func TestJoinWords(t *testing.T) {
+	tests := []struct {
+		words string
+		want  string
+	}{
+		{words: "吾輩 は 猫 で ある 。", want: "吾輩は猫である。"},
+		{words: "hello world", want: "hello world"},
+		{words: snippetOpen + "hello" + snippetClose + " world", want: snippetOpen + "hello" + snippetClose + " world"},
+		{words: "猫 " + snippetOpen + "cat" + snippetClose + " と 犬", want: "猫" + snippetOpen + "cat" + snippetClose + "と犬"},
+		{words: "…", want: "…"},
+	}
+	for _, test := range tests {
+		got := joinWords(test.words)
+		if got != test.want {
+			t.Errorf("%q: want %q, but got %q", test.words, test.want, got)
+		}
+	}
+}
+
+func TestSQLiteStoreReindex(t *testing.T) {
+	store := openTestStore(t)
+
+	// 単語を区切らずに登録していた以前のデータベースを再現する
+	_, err := store.DB().Exec(`INSERT INTO authors(author_id, author) values('999999', 'テスト 太郎')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.DB().Exec(`INSERT INTO contents(rowid, author_id, title_id, title, content) values(10, '999999', '001', 'テスト書籍001', '吾輩は猫である。')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.DB().Exec(`INSERT INTO contents_fts(rowid, words) values(10, '吾輩は猫である。'), (11, '孤立した行')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits, err := store.Search("猫")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 0 {
+		t.Fatalf("want no hits before Reindex, but got %+v", hits)
+	}
+
+	err = store.Reindex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits, err = store.Search("猫")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 {
+		t.Errorf("want 1 hit after Reindex, but got %+v", hits)
+	}
+	if n := countRows(t, store, "contents_fts"); n != 1 {
+		t.Errorf("want 1 row in contents_fts, but got %d", n)
+	}
+}
+
+func TestSQLiteStoreImages(t *testing.T) {
+	store := openTestStore(t)
+
+	entry := Entry{AuthorID: "999999", Author: "テスト 太郎", TitleID: "001", Title: "テスト書籍001"}
+	doc := store.Indexer().Document(&entry, "hello world")
+	doc.Images = []Image{{Name: "fig02.png", Data: []byte("b")}, {Name: "fig01.png", Data: []byte("a")}}
+	err := store.AddDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Images("999999", "001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Image{{Name: "fig01.png", Data: []byte("a")}, {Name: "fig02.png", Data: []byte("b")}}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %+v, but got %+v", want, got)
+	}
+
+	// 作品を登録し直すと古い挿絵は消える
+	err = store.AddEntry(&entry, "hello world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, store, "images"); n != 0 {
+		t.Errorf("want no images, but got %d", n)
+	}
+}
+
+func TestSQLiteStoreWorks(t *testing.T) {
+	store := openTestStore(t)
+
+	entries := []Entry{
+		{
+			AuthorID: "000879", Author: "芥川 竜之介", TitleID: "127", Title: "羅生門",
+			AuthorYomi: "あくたがわ りゅうのすけ", BirthDate: "1892-03-01",
+			TitleYomi: "らしょうもん", FirstAppearance: "「帝国文学」1915（大正4）年11月", CharType: "新字旧仮名",
+			ReleaseDate: "1997-11-04",
+		},
+		{
+			AuthorID: "000879", Author: "芥川 竜之介", TitleID: "42", Title: "鼻",
+			TitleYomi: "はな", FirstAppearance: "「新思潮」1916（大正5）年2月", CharType: "新字新仮名",
+			ReleaseDate: "1997-10-13",
+		},
+		{
+			AuthorID: "000001", Author: "テスト 太郎", TitleID: "1", Title: "テスト訳書",
+			TitleYomi: "てすとやくしょ", CharType: "新字新仮名", Translator: "テスト 花子", Copyright: true,
+		},
+	}
+	for i := range entries {
+		err := store.AddEntry(&entries[i], "本文")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	yes := true
+	tests := []struct {
+		name   string
+		filter WorkFilter
+		want   []string
+	}{
+		{name: "all", filter: WorkFilter{}, want: []string{"1", "42", "127"}},
+		{name: "title", filter: WorkFilter{Sort: SortByTitle}, want: []string{"1", "42", "127"}},
+		{name: "year", filter: WorkFilter{Sort: SortByYear}, want: []string{"127", "42", "1"}},
+		{name: "release", filter: WorkFilter{Sort: SortByRelease}, want: []string{"42", "127", "1"}},
+		{name: "author", filter: WorkFilter{AuthorID: "000879"}, want: []string{"42", "127"}},
+		{name: "char type", filter: WorkFilter{CharType: "新字新仮名"}, want: []string{"1", "42"}},
+		{name: "translated", filter: WorkFilter{Translated: true}, want: []string{"1"}},
+		{name: "from", filter: WorkFilter{FromYear: 1916}, want: []string{"42"}},
+		{name: "to", filter: WorkFilter{ToYear: 1915}, want: []string{"127"}},
+		{name: "copyright", filter: WorkFilter{Copyright: &yes}, want: []string{"1"}},
+	}
+	for _, tt := range tests {
+		works, err := store.Works(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, work := range works {
+			got = append(got, work.TitleID)
+		}
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("%s: want %v, but got %v", tt.name, tt.want, got)
+		}
+	}
+
+	_, err := store.Works(WorkFilter{Sort: "author"})
+	if err == nil {
+		t.Error("want error for unknown sort order")
+	}
+
+	work, err := store.Work("000879", "127")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := entries[0]
+	want.TitleSort = "らしようもん"
+	if !reflect.DeepEqual(&want, work) {
+		t.Errorf("want %+v, but got %+v", want, work)
+	}
+
+	_, err = store.Work("000879", "1")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("want ErrNotFound, but got %v", err)
+	}
+}
+
+func TestSQLiteStoreUnversioned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.sqlite")
+
+	// バージョンを記録する前の、詳細な情報の列が無いデータベースを再現する
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		`CREATE TABLE authors(author_id TEXT, author TEXT, PRIMARY KEY (author_id))`,
+		`CREATE TABLE contents(author_id TEXT, title_id TEXT, title TEXT, content TEXT, PRIMARY KEY (author_id, title_id))`,
+		`INSERT INTO authors(author_id, author) values('999999', 'テスト 太郎')`,
+		`INSERT INTO contents(author_id, title_id, title, content) values('999999', '001', 'テスト書籍001', '本文')`,
+	} {
+		_, err = db.Exec(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	work, err := store.Work("999999", "001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if work.Title != "テスト書籍001" || work.CharType != "" {
+		t.Errorf("want old entry with empty metadata, but got %+v", work)
+	}
+}
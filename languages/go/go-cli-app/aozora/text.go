@@ -0,0 +1,277 @@
+package aozora
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Segment は本文を区切った断片
+//
+// 通常の文字列は Text だけを持ち、ルビの振られた文字列は Text と Ruby を持つ。
+// ［＃...］ の注記は Note だけを持つ。Unicode の文字に置き換えられた外字は
+// 通常の文字列に含まれる。
+type Segment struct {
+	Text  string // 本文の文字列
+	Ruby  string // Text に振られたルビ
+	Note  string // 注記 (［＃ と ］ の間)
+	Gaiji *Gaiji // 解決できなかった外字。Text は GaijiPlaceholder になる
+}
+
+// Reading はルビの振られた文字列とその読み
+type Reading struct {
+	Base string
+	Ruby string
+}
+
+// Text は青空文庫形式のテキストを解析した結果
+type Text struct {
+	Title      string
+	Subtitle   string
+	Author     string
+	Translator string
+
+	Body     []Segment // 本文
+	Colophon string    // 底本などの情報
+	Gaiji    []*Gaiji  // 本文に現れた外字の注記を解決した結果
+}
+
+// ParseText は青空文庫形式のテキストを表題、本文、底本などの情報に分け、
+// 本文をルビと注記に分ける
+func ParseText(s string) *Text {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	lines := strings.Split(s, "\n")
+
+	t := &Text{}
+	header, start, end := splitText(lines)
+	t.parseHeader(lines[:header])
+	t.Body, t.Gaiji = parseBody(strings.Trim(strings.Join(lines[start:end], "\n"), "\n"))
+	t.Colophon = strings.Trim(strings.Join(lines[end:], "\n"), "\n")
+	return t
+}
+
+// isSeparator は記号の説明を囲む区切り線か調べる
+func isSeparator(line string) bool {
+	return strings.HasPrefix(line, "-----")
+}
+
+// splitText は表題が終わる行、本文が始まる行、底本の情報が始まる行を返す
+func splitText(lines []string) (int, int, int) {
+	// 表題は最初の空行まで。空行が無い場合は全て本文とする
+	header := -1
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			header = i
+			break
+		}
+	}
+	start := 0
+	if header >= 0 {
+		start = header + 1
+	}
+
+	// 表題の後に記号の説明があれば読み飛ばす
+	i := start
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+	separated := false
+	if i < len(lines) && isSeparator(lines[i]) {
+		for j := i + 1; j < len(lines); j++ {
+			if isSeparator(lines[j]) {
+				start = j + 1
+				separated = true
+				break
+			}
+		}
+	}
+
+	end := colophonStart(lines, start)
+	// 記号の説明も底本の情報も無いテキストは青空文庫形式ではないとみなし、全て本文とする
+	if header < 0 || (!separated && end == len(lines)) {
+		return 0, 0, colophonStart(lines, 0)
+	}
+	return header, start, end
+}
+
+// colophonStart は底本の情報が始まる行を返す。無い場合は行数を返す
+func colophonStart(lines []string, start int) int {
+	for i := len(lines) - 1; i >= start; i-- {
+		if strings.HasPrefix(lines[i], "底本：") {
+			return i
+		}
+	}
+	return len(lines)
+}
+
+func (t *Text) parseHeader(lines []string) {
+	header := []string{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			header = append(header, line)
+		}
+	}
+	if len(header) == 0 {
+		return
+	}
+
+	t.Title = header[0]
+	header = header[1:]
+	if n := len(header); n > 0 && strings.HasSuffix(header[n-1], "訳") {
+		t.Translator = strings.TrimSpace(strings.TrimSuffix(header[n-1], "訳"))
+		header = header[:n-1]
+	}
+	if n := len(header); n > 0 {
+		t.Author = header[n-1]
+		t.Subtitle = strings.Join(header[:n-1], " ")
+	}
+}
+
+// charClass はルビの掛かる範囲を決めるための文字種
+func charClass(r rune) int {
+	switch {
+	case unicode.Is(unicode.Han, r) || strings.ContainsRune("々〆〇ヶ仝", r):
+		return 1
+	case unicode.Is(unicode.Hiragana, r):
+		return 2
+	case unicode.Is(unicode.Katakana, r) || r == 'ー':
+		return 3
+	case unicode.IsLetter(r) || unicode.IsDigit(r):
+		return 4
+	}
+	return 0
+}
+
+// parseBody は本文をルビと注記に分け、外字の注記を解決する
+//
+// ルビは ｜ から 《 までの文字列、｜ が無い場合は 《 の直前の同じ文字種の
+// 文字列に掛かる。
+func parseBody(s string) ([]Segment, []*Gaiji) {
+	segs := []Segment{}
+	gaiji := []*Gaiji{}
+	rs := []rune(s)
+	buf := []rune{}
+	rubyStart := -1
+
+	flush := func() {
+		if len(buf) > 0 {
+			segs = append(segs, Segment{Text: string(buf)})
+			buf = buf[:0]
+		}
+		rubyStart = -1
+	}
+
+	for i := 0; i < len(rs); i++ {
+		switch {
+		case rs[i] == '｜':
+			rubyStart = len(buf)
+		case rs[i] == '《':
+			end := indexRune(rs, i+1, '》')
+			if end < 0 {
+				buf = append(buf, rs[i])
+				continue
+			}
+			ruby := string(rs[i+1 : end])
+			base := rubyStart
+			if base < 0 {
+				base = len(buf)
+				if base > 0 {
+					class := charClass(buf[base-1])
+					for base > 0 && charClass(buf[base-1]) == class {
+						base--
+						if class == 0 {
+							break
+						}
+					}
+				}
+			}
+			text := string(buf[base:])
+			buf = buf[:base]
+			flush()
+			segs = append(segs, Segment{Text: text, Ruby: ruby})
+			i = end
+		case rs[i] == '※' && i+2 < len(rs) && rs[i+1] == '［' && rs[i+2] == '＃':
+			end := closingBracket(rs, i+1)
+			if end < 0 {
+				buf = append(buf, rs[i])
+				continue
+			}
+			g := ResolveGaiji(string(rs[i+3 : end]))
+			gaiji = append(gaiji, g)
+			if g.Resolved() {
+				buf = append(buf, []rune(g.Char)...)
+			} else {
+				flush()
+				segs = append(segs, Segment{Text: GaijiPlaceholder, Gaiji: g})
+			}
+			i = end
+		case rs[i] == '［' && i+1 < len(rs) && rs[i+1] == '＃':
+			end := closingBracket(rs, i)
+			if end < 0 {
+				buf = append(buf, rs[i])
+				continue
+			}
+			flush()
+			segs = append(segs, Segment{Note: string(rs[i+2 : end])})
+			i = end
+		default:
+			if rs[i] == '\n' {
+				rubyStart = -1
+			}
+			buf = append(buf, rs[i])
+		}
+	}
+	flush()
+	return segs, gaiji
+}
+
+func indexRune(rs []rune, from int, r rune) int {
+	for i := from; i < len(rs); i++ {
+		if rs[i] == r {
+			return i
+		}
+		if rs[i] == '\n' {
+			break
+		}
+	}
+	return -1
+}
+
+// closingBracket は rs[start] の ［ に対応する ］ の位置を返す
+func closingBracket(rs []rune, start int) int {
+	depth := 0
+	for i := start; i < len(rs); i++ {
+		switch rs[i] {
+		case '［':
+			depth++
+		case '］':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		case '\n':
+			return -1
+		}
+	}
+	return -1
+}
+
+// Plain はルビと注記を取り除いた本文を返す
+func (t *Text) Plain() string {
+	var b strings.Builder
+	for _, seg := range t.Body {
+		b.WriteString(seg.Text)
+	}
+	return b.String()
+}
+
+// Readings は本文に振られたルビを出現順に返す
+func (t *Text) Readings() []Reading {
+	readings := []Reading{}
+	for _, seg := range t.Body {
+		if seg.Ruby != "" {
+			readings = append(readings, Reading{Base: seg.Text, Ruby: seg.Ruby})
+		}
+	}
+	return readings
+}
@@ -0,0 +1,96 @@
+package aozora
+
+import (
+	"reflect"
+	"testing"
+)
+
+const exampleText = "羅生門\r\n芥川龍之介\r\n\r\n" +
+	"-------------------------------------------------------\r\n" +
+	"【テキスト中に現れる記号について】\r\n\r\n" +
+	"《》：ルビ\r\n（例）下人《げにん》\r\n" +
+	"-------------------------------------------------------\r\n\r\n" +
+	"［＃３字下げ］一［＃「一」は中見出し］\r\n" +
+	"　或日の暮方の事である。一人の下人《げにん》が、｜羅生門《らしょうもん》の下で雨やみを待っていた。\r\n\r\n" +
+	"底本：「芥川龍之介全集1」ちくま文庫、筑摩書房\r\n" +
+	"入力：j.utiyama\r\n"
+
+func TestParseText(t *testing.T) {
+	text := ParseText(exampleText)
+
+	if text.Title != "羅生門" || text.Author != "芥川龍之介" || text.Subtitle != "" || text.Translator != "" {
+		t.Errorf("unexpected header: %+v", text)
+	}
+
+	want := "一\n　或日の暮方の事である。一人の下人が、羅生門の下で雨やみを待っていた。"
+	if got := text.Plain(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+
+	wantReadings := []Reading{{Base: "下人", Ruby: "げにん"}, {Base: "羅生門", Ruby: "らしょうもん"}}
+	if got := text.Readings(); !reflect.DeepEqual(wantReadings, got) {
+		t.Errorf("want %+v, but got %+v", wantReadings, got)
+	}
+
+	wantColophon := "底本：「芥川龍之介全集1」ちくま文庫、筑摩書房\n入力：j.utiyama"
+	if text.Colophon != wantColophon {
+		t.Errorf("want %q, but got %q", wantColophon, text.Colophon)
+	}
+
+	wantBody := []Segment{
+		{Note: "３字下げ"},
+		{Text: "一"},
+		{Note: "「一」は中見出し"},
+	}
+	if got := text.Body[:3]; !reflect.DeepEqual(wantBody, got) {
+		t.Errorf("want %+v, but got %+v", wantBody, got)
+	}
+}
+
+func TestParseTextHeader(t *testing.T) {
+	text := ParseText("変身\r\nDIE VERWANDLUNG\r\nフランツ・カフカ\r\n原田義人訳\r\n\r\n本文\r\n\r\n底本：「世界文学全集」\r\n")
+
+	if text.Title != "変身" || text.Subtitle != "DIE VERWANDLUNG" || text.Author != "フランツ・カフカ" || text.Translator != "原田義人" {
+		t.Errorf("unexpected header: %+v", text)
+	}
+	if got := text.Plain(); got != "本文" {
+		t.Errorf("want %q, but got %q", "本文", got)
+	}
+}
+
+func TestParseTextPlain(t *testing.T) {
+	// 青空文庫形式でないテキストは全て本文とする
+	text := ParseText("テストデータ\n")
+	if got := text.Plain(); got != "テストデータ" {
+		t.Errorf("want %q, but got %q", "テストデータ", got)
+	}
+	if text.Title != "" {
+		t.Errorf("want no title, but got %q", text.Title)
+	}
+}
+
+func TestParseBodyRuby(t *testing.T) {
+	tests := []struct {
+		body string
+		want []Segment
+	}{
+		{
+			body: "ひらがなと漢字《かんじ》",
+			want: []Segment{{Text: "ひらがなと"}, {Text: "漢字", Ruby: "かんじ"}},
+		},
+		{
+			body: "軍艦｜三笠《みかさ》",
+			want: []Segment{{Text: "軍艦"}, {Text: "三笠", Ruby: "みかさ"}},
+		},
+		{
+			body: "閉じていない《ルビ",
+			want: []Segment{{Text: "閉じていない《ルビ"}},
+		},
+	}
+	for _, tt := range tests {
+		got, _ := parseBody(tt.body)
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("%q: want %+v, but got %+v", tt.body, tt.want, got)
+		}
+	}
+}
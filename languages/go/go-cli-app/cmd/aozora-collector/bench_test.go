@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ikawaha/kagome-dict/ipa"
+	"github.com/ikawaha/kagome/v2/tokenizer"
+	"github.com/yuichi04/aozora-search/aozora"
+)
+
+var benchContent = strings.Repeat("丸善の二階の書棚には、いつも新しい洋書が並んでゐた。\n", 100)
+
+// BenchmarkTokenizerPerEntry は作品ごとに tokenizer を作っていた以前の addEntry 相当
+//
+// ipa.Dict は初回の読み込み (1 秒程度) の結果を保持するので、計測からは除いている。
+func BenchmarkTokenizerPerEntry(b *testing.B) {
+	ipa.Dict()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		t, err := tokenizer.New(ipa.Dict(), tokenizer.OmitBosEos())
+		if err != nil {
+			b.Fatal(err)
+		}
+		t.Wakati(benchContent)
+	}
+}
+
+// BenchmarkSharedIndexer は一つの Indexer を使い回す場合
+func BenchmarkSharedIndexer(b *testing.B) {
+	ix, err := aozora.NewIndexer()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		ix.Words(benchContent)
+	}
+}
+
+// BenchmarkSharedIndexerParallel は一つの Indexer を複数の goroutine で共有する場合
+func BenchmarkSharedIndexerParallel(b *testing.B) {
+	ix, err := aozora.NewIndexer()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ix.Words(benchContent)
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errNotCached はオフラインのキャッシュに URL の内容が無い場合のエラー
+var errNotCached = errors.New("not found in the offline cache")
+
+// Cache は取得したページと ZIP ファイルを保存するディスク上のキャッシュ
+//
+// 内容は SHA-256 を名前にして objects/ に保存し、URL から内容のハッシュへの
+// 対応を urls/ に保存する。同じ内容は一つしか保存しない。
+// Cache は複数の goroutine から同時に使ってよい。
+type Cache struct {
+	dir string
+}
+
+// NewCache は dir をキャッシュのディレクトリにする
+func NewCache(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// hashHex は b の SHA-256 を 16 進数で返す
+func hashHex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) objectPath(hash string) string {
+	return filepath.Join(c.dir, "objects", hash[:2], hash)
+}
+
+func (c *Cache) urlPath(url string) string {
+	return filepath.Join(c.dir, "urls", hashHex([]byte(url)))
+}
+
+// Put は url の内容を保存する
+func (c *Cache) Put(url string, data []byte) error {
+	hash := hashHex(data)
+	path := c.objectPath(hash)
+	if _, err := os.Stat(path); err != nil {
+		err = writeFileAtomic(path, data)
+		if err != nil {
+			return err
+		}
+	}
+	// URL とハッシュを一行ずつ書いておくと、後から何が入っているか調べられる
+	return writeFileAtomic(c.urlPath(url), []byte(hash+"\n"+url+"\n"))
+}
+
+// lookup は url の内容を保存したファイルのパスを返す。無い場合は errNotCached を返す
+func (c *Cache) lookup(url string) (string, error) {
+	b, err := os.ReadFile(c.urlPath(url))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", errNotCached
+		}
+		return "", err
+	}
+	hash, _, _ := strings.Cut(string(b), "\n")
+	if len(hash) != sha256.Size*2 {
+		return "", errNotCached
+	}
+	return c.objectPath(hash), nil
+}
+
+// Has は url の内容が保存されているかどうかを返す
+func (c *Cache) Has(url string) bool {
+	path, err := c.lookup(url)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// Get は url の内容を返す。無い場合は errNotCached を返す
+func (c *Cache) Get(url string) ([]byte, error) {
+	path, err := c.lookup(url)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errNotCached
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// response はキャッシュの内容を req へのレスポンスとして返す
+func (c *Cache) response(req *http.Request) (*http.Response, error) {
+	data, err := c.Get(req.URL.String())
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    200,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
+
+// store は 200 のレスポンスの本文をキャッシュに保存し、読み直せるようにして返す
+func (c *Cache) store(resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode != 200 || resp.Request.Method != http.MethodGet {
+		return resp, nil
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = c.Put(resp.Request.URL.String(), data)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return resp, nil
+}
+
+// writeFileAtomic は一時ファイルに書いてから名前を変えることで、書きかけのファイルを残さない
+func writeFileAtomic(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCacheOffline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`
+			<ol>
+			<li><a href="../cards/999999/card001.html">テスト書籍001</a></li>
+			<li><a href="../cards/999999/card002.html">テスト書籍002</a></li>
+			</ol>
+			`))
+		case "/cards/999999/card001.html", "/cards/999999/card002.html":
+			// 二つの作品ページは同じ内容なので、キャッシュには一つだけ保存される
+			w.Write([]byte(`
+			<table summary="作家データ">
+			<tr><td class="header">作家名：</td><td>テスト 太郎</td></tr>
+			</table>
+			<table border="1" summary="ダウンロードデータ" class="download">
+			<tr><td><a href="./files/example.zip">example.zip</a></td></tr>
+			</table>
+			`))
+		case "/cards/999999/files/example.zip":
+			http.ServeFile(w, r, "testdata/example.zip")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	tmp := pageURLFormat
+	pageURLFormat = ts.URL + "/cards/%s/card%s.html"
+	defer func() {
+		pageURLFormat = tmp
+	}()
+
+	cache := NewCache(t.TempDir())
+	client := newFetchClient()
+	client.Cache = cache
+
+	want, _, err := findEntries(context.Background(), client, ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range want {
+		_, err = extractText(client, entry.ZipURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// サーバを止めてもキャッシュから同じ結果が得られる
+	ts.Close()
+	offline := newFetchClient()
+	offline.Cache = cache
+	offline.Offline = true
+
+	got, failed, err := findEntries(context.Background(), offline, ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 0 {
+		t.Errorf("want no failures, but got %v", failed)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %+v, but got %+v", want, got)
+	}
+
+	text, err := extractText(offline, got[0].ZipURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "テストデータ\n" {
+		t.Errorf("want %q, but got %q", "テストデータ\n", text)
+	}
+
+	_, err = extractText(offline, ts.URL+"/cards/999999/files/notfound.zip")
+	if !errors.Is(err, errNotCached) {
+		t.Errorf("want errNotCached, but got %v", err)
+	}
+}
+
+func TestCachePut(t *testing.T) {
+	cache := NewCache(t.TempDir())
+
+	err := cache.Put("https://example.com/a", []byte("same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cache.Put("https://example.com/b", []byte("same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, url := range []string{"https://example.com/a", "https://example.com/b"} {
+		got, err := cache.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "same" {
+			t.Errorf("%s: want %q, but got %q", url, "same", got)
+		}
+	}
+
+	_, err = cache.Get("https://example.com/c")
+	if !errors.Is(err, errNotCached) {
+		t.Errorf("want errNotCached, but got %v", err)
+	}
+	if !cache.Has("https://example.com/a") || cache.Has("https://example.com/c") {
+		t.Error("want only cached URLs to be reported by Has")
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultUserAgent は収集に使う User-Agent の既定値
+const defaultUserAgent = "aozora-collector/1.0"
+
+// errDisallowed は robots.txt で収集が禁止されている URL へのリクエストのエラー
+var errDisallowed = errors.New("disallowed by robots.txt")
+
+// Client は青空文庫のサーバに負荷を掛けないように収集するための HTTP クライアント
+//
+// リクエストのタイムアウト、User-Agent の設定、ホストごとのトークンバケットによる
+// 流量の制限、robots.txt の遵守、429 と 5xx とネットワークエラーの指数バックオフでの
+// 再試行を行う。Client は複数の goroutine から同時に使ってよい。
+type Client struct {
+	HTTP         *http.Client
+	UserAgent    string
+	Rate         float64       // ホストごとの一秒あたりのリクエスト数。0 以下なら制限しない
+	Burst        int           // 待たずに続けて送れるリクエスト数
+	MaxRetries   int           // 再試行の回数
+	BaseDelay    time.Duration // 最初の再試行までの待ち時間
+	MaxDelay     time.Duration // 再試行までの待ち時間の上限
+	IgnoreRobots bool          // robots.txt を無視する
+	Cache        *Cache        // nil でなければ取得した内容を保存する
+	Offline      bool          // サーバに接続せず、Cache だけから読む
+
+	// テストで実際の時間を掛けずに確かめるために差し替える
+	now    func() time.Time
+	sleep  func(ctx context.Context, d time.Duration) error
+	jitter func(d time.Duration) time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+
+	robotsMu sync.Mutex
+	robots   map[string]*robotsRules // ホストごとの robots.txt の規則
+}
+
+// NewClient は timeout でリクエストを打ち切り、ホストごとに一秒あたり rate 回まで
+// リクエストを送る Client を作る
+func NewClient(userAgent string, timeout time.Duration, rate float64) *Client {
+	return &Client{
+		HTTP:       &http.Client{Timeout: timeout},
+		UserAgent:  userAgent,
+		Rate:       rate,
+		Burst:      1,
+		MaxRetries: 3,
+		BaseDelay:  time.Second,
+		MaxDelay:   time.Minute,
+		now:        time.Now,
+		sleep:      sleepContext,
+		jitter:     equalJitter,
+		buckets:    map[string]*bucket{},
+		robots:     map[string]*robotsRules{},
+	}
+}
+
+// sleepContext は d だけ待つ。ctx がキャンセルされた場合は ctx.Err() を返す
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// equalJitter は待ち時間を d/2 から d の間でばらつかせる
+func equalJitter(d time.Duration) time.Duration {
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Get は url に GET リクエストを送る
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do は robots.txt で許可されていればリクエストを送る
+//
+// 429 と 5xx のレスポンス、ネットワークエラーの場合は MaxRetries 回まで再試行する。
+// 再試行しても失敗した場合は最後のレスポンスかエラーを返す。
+// Offline の場合はリクエストを送らずに Cache から返し、無ければ errNotCached を返す。
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if c.Offline {
+		if c.Cache == nil {
+			return nil, errNotCached
+		}
+		return c.Cache.response(req)
+	}
+
+	if !c.IgnoreRobots {
+		rules, err := c.loadRobots(req.Context(), req.URL)
+		if err != nil {
+			return nil, err
+		}
+		if !rules.allowed(robotsPath(req.URL)) {
+			return nil, errDisallowed
+		}
+	}
+
+	resp, err := c.do(req)
+	if err != nil || c.Cache == nil {
+		return resp, err
+	}
+	return c.Cache.store(resp)
+}
+
+// do は流量を制限し、失敗したリクエストを再試行する
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		err := c.wait(ctx, req.URL.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		r := req.Clone(ctx)
+		if c.UserAgent != "" {
+			r.Header.Set("User-Agent", c.UserAgent)
+		}
+		resp, err := c.HTTP.Do(r)
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !retryable(resp, err) || attempt >= c.MaxRetries {
+			return resp, err
+		}
+
+		delay := c.backoff(attempt)
+		if resp != nil {
+			if after := retryAfter(resp); after > delay {
+				delay = after
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		err = c.sleep(ctx, delay)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryable は再試行すべき失敗か調べる
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// backoff は attempt 回目の再試行までの待ち時間を返す
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.BaseDelay << attempt
+	if d <= 0 || d > c.MaxDelay {
+		d = c.MaxDelay
+	}
+	return c.jitter(d)
+}
+
+// retryAfter は Retry-After ヘッダの秒数を返す。無い場合は 0 を返す
+func retryAfter(resp *http.Response) time.Duration {
+	n, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// bucket はホストごとのトークンバケット
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// wait はホストのトークンバケットからトークンを一つ取り出す。足りない場合は溜まるまで待つ
+func (c *Client) wait(ctx context.Context, host string) error {
+	c.mu.Lock()
+	rate := c.Rate
+	// robots.txt の Crawl-delay の方が長ければそれに従う
+	if rules := c.cachedRobots(host); rules != nil && rules.crawlDelay > 0 {
+		if r := float64(time.Second) / float64(rules.crawlDelay); rate <= 0 || r < rate {
+			rate = r
+		}
+	}
+	if rate <= 0 {
+		c.mu.Unlock()
+		return nil
+	}
+
+	burst := float64(max(c.Burst, 1))
+	now := c.now()
+	b, ok := c.buckets[host]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		c.buckets[host] = b
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	// 先にトークンを予約しておくことで、同時に待っている goroutine が順に送れる
+	b.tokens--
+	var d time.Duration
+	if b.tokens < 0 {
+		d = time.Duration(-b.tokens / rate * float64(time.Second))
+	}
+	c.mu.Unlock()
+
+	if d > 0 {
+		return c.sleep(ctx, d)
+	}
+	return nil
+}
+
+// cachedRobots は読み込み済みのホストの robots.txt の規則を返す
+func (c *Client) cachedRobots(host string) *robotsRules {
+	c.robotsMu.Lock()
+	defer c.robotsMu.Unlock()
+	return c.robots[host]
+}
+
+// loadRobots は u のホストの robots.txt を読み込み、規則を返す
+//
+// robots.txt が無い (4xx) 場合は全て許可する。サーバのエラーなどで読み込めない
+// 場合はエラーを返し、次のリクエストで読み込み直す。
+func (c *Client) loadRobots(ctx context.Context, u *url.URL) (*robotsRules, error) {
+	c.robotsMu.Lock()
+	rules, ok := c.robots[u.Host]
+	c.robotsMu.Unlock()
+	if ok {
+		return rules, nil
+	}
+
+	robotsURL := u.Scheme + "://" + u.Host + "/robots.txt"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, &NetworkError{URL: robotsURL, Err: err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 200:
+		rules = parseRobots(resp.Body, c.UserAgent)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		rules = &robotsRules{}
+	default:
+		return nil, &StatusError{URL: robotsURL, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	c.robotsMu.Lock()
+	c.robots[u.Host] = rules
+	c.robotsMu.Unlock()
+	return rules, nil
+}
+
+// robotsRules は robots.txt のうち自分に当てはまる規則
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// robotsRule は robots.txt の Allow または Disallow の一行
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// allowed は path へのリクエストが許可されているか調べる
+//
+// 最も長いパターンに当てはまる規則を使い、同じ長さなら Allow を優先する。
+func (r *robotsRules) allowed(path string) bool {
+	allow := true
+	length := -1
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > length || (len(rule.pattern) == length && rule.allow) {
+			allow = rule.allow
+			length = len(rule.pattern)
+		}
+	}
+	return allow
+}
+
+// robotsPath は robots.txt の規則と照らし合わせる URL のパスとクエリ
+func robotsPath(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+	return p
+}
+
+// robotsMatch は path が robots.txt のパターンに当てはまるか調べる
+//
+// パターンの * は任意の文字列に、末尾の $ はパスの終わりに当てはまる。
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	parts := strings.Split(strings.TrimSuffix(pattern, "$"), "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	if len(parts) == 1 {
+		return !anchored || rest == ""
+	}
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(rest, last)
+	}
+	return strings.Contains(rest, last)
+}
+
+// parseRobots は robots.txt から userAgent に当てはまる規則を取り出す
+//
+// userAgent の製品名 (aozora-collector/1.0 の aozora-collector) に一致する
+// グループがあればその規則を、無ければ * のグループの規則を使う。
+func parseRobots(r io.Reader, userAgent string) *robotsRules {
+	product, _, _ := strings.Cut(userAgent, "/")
+	product = strings.TrimSpace(product)
+
+	type group struct {
+		agents []string
+		rules  robotsRules
+	}
+	groups := []*group{}
+	var current *group
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// 規則の後の User-agent は新しいグループを始める
+			if current == nil || len(current.rules.rules) > 0 || current.rules.crawlDelay > 0 {
+				current = &group{}
+				groups = append(groups, current)
+			}
+			current.agents = append(current.agents, value)
+		case "allow", "disallow":
+			if current == nil || value == "" {
+				continue
+			}
+			current.rules.rules = append(current.rules.rules, robotsRule{allow: key == "allow", pattern: value})
+		case "crawl-delay":
+			if current == nil {
+				continue
+			}
+			if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+				current.rules.crawlDelay = time.Duration(secs * float64(time.Second))
+			}
+		}
+	}
+
+	matched := func(agent func(string) bool) *robotsRules {
+		var rules *robotsRules
+		for _, g := range groups {
+			for _, a := range g.agents {
+				if !agent(a) {
+					continue
+				}
+				if rules == nil {
+					rules = &robotsRules{}
+				}
+				rules.rules = append(rules.rules, g.rules.rules...)
+				rules.crawlDelay = max(rules.crawlDelay, g.rules.crawlDelay)
+				break
+			}
+		}
+		return rules
+	}
+	if rules := matched(func(a string) bool { return product != "" && strings.EqualFold(a, product) }); rules != nil {
+		return rules
+	}
+	if rules := matched(func(a string) bool { return a == "*" }); rules != nil {
+		return rules
+	}
+	return &robotsRules{}
+}
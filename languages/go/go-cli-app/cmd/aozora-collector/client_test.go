@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock は sleep で時間を進めるだけの時計
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.sleeps = append(c.sleeps, d)
+	return ctx.Err()
+}
+
+// newTestClient は実際には待たず、待ち時間をばらつかせない Client を作る
+func newTestClient(rate float64) (*Client, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := NewClient("aozora-collector-test/1.0", 10*time.Second, rate)
+	c.now = clock.Now
+	c.sleep = clock.Sleep
+	c.jitter = func(d time.Duration) time.Duration { return d }
+	return c, clock
+}
+
+func TestClientRetry(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	agents := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		agents = append(agents, r.UserAgent())
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "10")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer ts.Close()
+
+	c, clock := newTestClient(0)
+	resp, err := c.Get(context.Background(), ts.URL+"/page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("want 200, but got %d", resp.StatusCode)
+	}
+
+	// 一回目は BaseDelay、二回目は BaseDelay*2 より長い Retry-After だけ待つ
+	want := []time.Duration{time.Second, 10 * time.Second}
+	if !reflect.DeepEqual(want, clock.sleeps) {
+		t.Errorf("want sleeps %v, but got %v", want, clock.sleeps)
+	}
+	for _, agent := range agents {
+		if agent != "aozora-collector-test/1.0" {
+			t.Errorf("want User-Agent %q, but got %q", "aozora-collector-test/1.0", agent)
+		}
+	}
+}
+
+func TestClientRetryGiveUp(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	c, clock := newTestClient(0)
+	c.IgnoreRobots = true
+	c.MaxRetries = 3
+	c.MaxDelay = 3 * time.Second
+	resp, err := c.Get(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("want 500, but got %d", resp.StatusCode)
+	}
+	if requests != 4 {
+		t.Errorf("want 4 requests, but got %d", requests)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	if !reflect.DeepEqual(want, clock.sleeps) {
+		t.Errorf("want sleeps %v, but got %v", want, clock.sleeps)
+	}
+}
+
+func TestClientRetryNetworkError(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	url := ts.URL
+	ts.Close()
+
+	c, clock := newTestClient(0)
+	c.IgnoreRobots = true
+	c.MaxRetries = 2
+	_, err := c.Get(context.Background(), url)
+	if err == nil {
+		t.Fatal("want error, but got nil")
+	}
+	if len(clock.sleeps) != 2 {
+		t.Errorf("want 2 retries, but got %v", clock.sleeps)
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	c, clock := newTestClient(2)
+	c.IgnoreRobots = true
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(context.Background(), ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// 最初の一回は待たず、その後は 1/2 秒ずつ待つ
+	want := []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}
+	if !reflect.DeepEqual(want, clock.sleeps) {
+		t.Errorf("want sleeps %v, but got %v", want, clock.sleeps)
+	}
+
+	// 別のホストのトークンバケットは別に数える
+	other := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	resp, err := c.Get(context.Background(), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(clock.sleeps) != 2 {
+		t.Errorf("want no wait for another host, but got %v", clock.sleeps)
+	}
+}
+
+func TestClientRobots(t *testing.T) {
+	robots := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robots++
+			w.Write([]byte(`
+User-agent: *
+Disallow: /
+
+User-agent: aozora-collector-test
+Disallow: /cards/*.zip$
+Allow: /cards/000001/
+Crawl-delay: 4
+`))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	c, clock := newTestClient(0)
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{path: "/cards/000002/card1.html", allowed: true},
+		{path: "/cards/000002/files/1_ruby.zip", allowed: false},
+		{path: "/cards/000001/files/1_ruby.zip", allowed: true},
+		{path: "/cards/000002/files/1_ruby.zip?x=1", allowed: true},
+	}
+	for _, tt := range tests {
+		resp, err := c.Get(context.Background(), ts.URL+tt.path)
+		if tt.allowed {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.path, err)
+				continue
+			}
+			resp.Body.Close()
+		} else if !errors.Is(err, errDisallowed) {
+			t.Errorf("%s: want errDisallowed, but got %v", tt.path, err)
+		}
+	}
+	if robots != 1 {
+		t.Errorf("want robots.txt fetched once, but got %d", robots)
+	}
+
+	// Crawl-delay に従って 4 秒ずつ待つ
+	want := []time.Duration{4 * time.Second, 4 * time.Second}
+	if !reflect.DeepEqual(want, clock.sleeps) {
+		t.Errorf("want sleeps %v, but got %v", want, clock.sleeps)
+	}
+}
+
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "/", path: "/index.html", want: true},
+		{pattern: "/cards/", path: "/cards/000879/card1.html", want: true},
+		{pattern: "/cards/", path: "/index_pages/", want: false},
+		{pattern: "/*.zip$", path: "/cards/000879/files/1.zip", want: true},
+		{pattern: "/*.zip$", path: "/cards/000879/files/1.zip.html", want: false},
+		{pattern: "/index.html$", path: "/index.html", want: true},
+		{pattern: "/index.html$", path: "/index.html?a=1", want: false},
+		{pattern: "/*/files/", path: "/cards/000879/files/1.zip", want: true},
+	}
+	for _, tt := range tests {
+		got := robotsMatch(tt.pattern, tt.path)
+		if got != tt.want {
+			t.Errorf("%q %q: want %v, but got %v", tt.pattern, tt.path, tt.want, got)
+		}
+	}
+}
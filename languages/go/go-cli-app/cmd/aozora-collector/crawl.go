@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/yuichi04/aozora-search/aozora"
+)
+
+var (
+	// listURLFormat は作者ごとの作品一覧のページ
+	listURLFormat = "https://www.aozora.gr.jp/index_pages/person%s.html"
+	// indexURLFormat は作家索引のページ
+	indexURLFormat = "https://www.aozora.gr.jp/index_pages/person_all_%s.html"
+)
+
+// indexPages は作家索引のページの一覧 (あ行からわ行、その他)
+var indexPages = []string{"a", "ka", "sa", "ta", "na", "ha", "ma", "ya", "ra", "wa", "zz"}
+
+// listURL は作者 ID または作品一覧の URL を作品一覧の URL にする
+func listURL(arg string) (string, error) {
+	if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
+		return arg, nil
+	}
+
+	// 作者 ID は 000879 のように 0 埋めされていることがある
+	id, err := strconv.Atoi(arg)
+	if err != nil || id <= 0 {
+		return "", fmt.Errorf("invalid author ID or URL: %q", arg)
+	}
+	return fmt.Sprintf(listURLFormat, strconv.Itoa(id)), nil
+}
+
+// findAuthors は作家索引のページから作者ごとの作品一覧のページの URL を探す
+func findAuthors(ctx context.Context, client *Client, indexURL string) ([]string, error) {
+	doc, err := getResopnseBody(ctx, client, indexURL)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(indexURL)
+	if err != nil {
+		return nil, &ParseError{URL: indexURL, Err: err}
+	}
+
+	pat := regexp.MustCompile(`(^|/)person([0-9]+)\.html(#.*)?$`)
+
+	urls := []string{}
+	seen := map[string]bool{}
+	doc.Find("ol li a").Each(func(n int, elem *goquery.Selection) {
+		href := elem.AttrOr("href", "")
+		if !pat.MatchString(href) {
+			return
+		}
+		u, err := base.Parse(href)
+		if err != nil {
+			return
+		}
+		u.Fragment = ""
+		if seen[u.String()] {
+			return
+		}
+		seen[u.String()] = true
+		urls = append(urls, u.String())
+	})
+	return urls, nil
+}
+
+// findAllAuthors は全ての作家索引のページを巡回して作品一覧のページの URL を集める
+//
+// 取得できなかった索引のページは読み飛ばし、EntryError として返す。
+// ctx がキャンセルされた場合はそれまでに集めた URL を返す。
+func findAllAuthors(ctx context.Context, client *Client) ([]string, []*EntryError) {
+	urls := []string{}
+	failed := []*EntryError{}
+	for _, page := range indexPages {
+		indexURL := fmt.Sprintf(indexURLFormat, page)
+		found, err := findAuthors(ctx, client, indexURL)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			failed = append(failed, &EntryError{PageURL: indexURL, Title: "person_all_" + page, Err: err})
+			continue
+		}
+		urls = append(urls, found...)
+	}
+	return urls, failed
+}
+
+// findAllEntries は作品一覧のページを順に巡回して作品を集める
+//
+// 共著や翻訳の作品は複数の作者の一覧に現れるので、重複は取り除く。
+// 取得できなかったページや作品は読み飛ばし、EntryError として返す。
+// ctx がキャンセルされた場合はそれまでに集めた作品を返す。
+func findAllEntries(ctx context.Context, client *Client, listURLs []string) ([]aozora.Entry, []*EntryError) {
+	entries := []aozora.Entry{}
+	failed := []*EntryError{}
+	seen := map[string]bool{}
+	for _, listURL := range listURLs {
+		found, skipped, err := findEntries(ctx, client, listURL)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			failed = append(failed, &EntryError{PageURL: listURL, Title: "作品一覧", Err: err})
+			continue
+		}
+		failed = append(failed, skipped...)
+
+		for _, entry := range found {
+			key := entry.AuthorID + "/" + entry.TitleID
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			entries = append(entries, entry)
+		}
+	}
+	return entries, failed
+}
+
+// indexPagePat は作家索引のページの URL
+var indexPagePat = regexp.MustCompile(`/person_all_[a-z]+\.html$`)
+
+// listPagePat は作者ごとの作品一覧のページの URL
+var listPagePat = regexp.MustCompile(`/person[0-9]+\.html$`)
+
+// findFailedEntries は前回の収集で作品を探す段階で取得できなかったページから作品を探し直す
+//
+// 作品ページは作品を、作家索引と作品一覧のページはそこから辿れる作品を探す。
+// カタログの不正な行のように探し直せないものは前回の理由のまま EntryError として返す。
+// ctx がキャンセルされた場合はそれまでに集めた作品を返す。
+func findFailedEntries(ctx context.Context, client *Client, items []aozora.CrawlItem) ([]aozora.Entry, []*EntryError) {
+	entries := []aozora.Entry{}
+	failed := []*EntryError{}
+	listURLs := []string{}
+	for _, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		e := item.Entry
+		switch {
+		case e.AuthorID != "" && e.TitleID != "":
+			pageURL := fmt.Sprintf(pageURLFormat, e.AuthorID, e.TitleID)
+			entry, err := findCard(ctx, client, pageURL)
+			if ctx.Err() != nil {
+				continue
+			}
+			if err != nil {
+				failed = append(failed, &EntryError{PageURL: e.SiteURL, Title: e.Title, Err: err})
+				continue
+			}
+			entry.AuthorID = e.AuthorID
+			entry.TitleID = e.TitleID
+			entry.Title = e.Title
+			entry.SiteURL = pageURL
+			entries = append(entries, *entry)
+		case indexPagePat.MatchString(e.SiteURL):
+			found, err := findAuthors(ctx, client, e.SiteURL)
+			if ctx.Err() != nil {
+				continue
+			}
+			if err != nil {
+				failed = append(failed, &EntryError{PageURL: e.SiteURL, Title: e.Title, Err: err})
+				continue
+			}
+			listURLs = append(listURLs, found...)
+		case listPagePat.MatchString(e.SiteURL):
+			listURLs = append(listURLs, e.SiteURL)
+		default:
+			failed = append(failed, &EntryError{PageURL: e.SiteURL, Title: e.Title, Err: errors.New(item.Error)})
+		}
+	}
+
+	found, skipped := findAllEntries(ctx, client, listURLs)
+	return append(entries, found...), append(failed, skipped...)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/yuichi04/aozora-search/aozora"
+)
+
+// newAozoraServer は作家索引、作品一覧、作品ページを返すテスト用のサーバを作る
+func newAozoraServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	pat := regexp.MustCompile(`^/cards/([0-9]+)/card([0-9]+).html$`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index_pages/person_all_a.html":
+			w.Write([]byte(`
+			<ol>
+			<li><a href="person1.html#sakuhin_list_1">テスト 一郎</a>　(公開中：2)</li>
+			<li><a href="person2.html#sakuhin_list_1">テスト 二郎</a>　(公開中：1)</li>
+			<li><a href="person1.html#sakuhin_list_2">テスト 一郎</a>　(公開中：2)</li>
+			</ol>
+			`))
+		case "/index_pages/person_all_ka.html":
+			w.Write([]byte(`<ol><li><a href="person3.html">テスト 三郎</a></li></ol>`))
+		case "/index_pages/person1.html":
+			w.Write([]byte(`
+			<ol>
+			<li><a href="../cards/000001/card001.html">テスト書籍001</a></li>
+			<li><a href="../cards/000001/card002.html">テスト書籍002</a></li>
+			</ol>
+			`))
+		case "/index_pages/person2.html":
+			// 共著の作品は両方の作者の一覧に現れる
+			w.Write([]byte(`
+			<ol>
+			<li><a href="../cards/000001/card002.html">テスト書籍002</a></li>
+			<li><a href="../cards/000002/card003.html">テスト書籍003</a></li>
+			</ol>
+			`))
+		default:
+			token := pat.FindStringSubmatch(r.URL.Path)
+			if token == nil {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(fmt.Sprintf(`
+			<table summary="作家データ">
+			<tr><td class="header">作家名：</td><td>テスト %[1]s</td></tr>
+			</table>
+			<table border="1" summary="ダウンロードデータ" class="download">
+			<tr><td><a href="./files/%[1]s_%[2]s.zip">%[1]s_%[2]s.zip</a></td></tr>
+			</table>
+			`, token[1], token[2])))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	formats := []*string{&pageURLFormat, &listURLFormat, &indexURLFormat}
+	saved := []string{pageURLFormat, listURLFormat, indexURLFormat}
+	pageURLFormat = ts.URL + "/cards/%s/card%s.html"
+	listURLFormat = ts.URL + "/index_pages/person%s.html"
+	indexURLFormat = ts.URL + "/index_pages/person_all_%s.html"
+	t.Cleanup(func() {
+		for i, f := range formats {
+			*f = saved[i]
+		}
+	})
+	return ts
+}
+
+func TestListURL(t *testing.T) {
+	tests := []struct {
+		arg     string
+		want    string
+		wantErr bool
+	}{
+		{arg: "879", want: "https://www.aozora.gr.jp/index_pages/person879.html"},
+		{arg: "000879", want: "https://www.aozora.gr.jp/index_pages/person879.html"},
+		{arg: "https://example.com/person1.html", want: "https://example.com/person1.html"},
+		{arg: "akutagawa", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := listURL(tt.arg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: unexpected error %v", tt.arg, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: want %q, but got %q", tt.arg, tt.want, got)
+		}
+	}
+}
+
+func TestFindAllAuthors(t *testing.T) {
+	ts := newAozoraServer(t)
+
+	got, failed := findAllAuthors(context.Background(), newFetchClient())
+	want := []string{
+		ts.URL + "/index_pages/person1.html",
+		ts.URL + "/index_pages/person2.html",
+		ts.URL + "/index_pages/person3.html",
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %v, but got %v", want, got)
+	}
+
+	// person_all_a と person_all_ka 以外の索引のページは存在しない
+	if len(failed) != len(indexPages)-2 {
+		t.Errorf("want %d failures, but got %d", len(indexPages)-2, len(failed))
+	}
+}
+
+func TestFindAllEntries(t *testing.T) {
+	ts := newAozoraServer(t)
+
+	listURLs, _ := findAllAuthors(context.Background(), newFetchClient())
+	got, failed := findAllEntries(context.Background(), newFetchClient(), listURLs)
+
+	titles := []string{}
+	for _, entry := range got {
+		titles = append(titles, entry.AuthorID+"/"+entry.TitleID)
+	}
+	want := []string{"000001/001", "000001/002", "000002/003"}
+	if !reflect.DeepEqual(want, titles) {
+		t.Errorf("want %v, but got %v", want, titles)
+	}
+
+	// person3.html は存在しない
+	if len(failed) != 1 || failed[0].PageURL != ts.URL+"/index_pages/person3.html" {
+		t.Errorf("want failure for person3.html, but got %v", failed)
+	}
+}
+
+func TestFindFailedEntries(t *testing.T) {
+	ts := newAozoraServer(t)
+
+	items := []aozora.CrawlItem{
+		{Entry: aozora.Entry{AuthorID: "000002", TitleID: "003", Title: "テスト書籍003", SiteURL: ts.URL + "/cards/000002/card003.html"}},
+		{Entry: aozora.Entry{Title: "person_all_ka", SiteURL: ts.URL + "/index_pages/person_all_ka.html"}},
+		{Entry: aozora.Entry{Title: "作品一覧", SiteURL: ts.URL + "/index_pages/person1.html"}},
+		// カタログの不正な行は探し直せない
+		{Entry: aozora.Entry{Title: "テスト書籍004", SiteURL: "card004"}, Error: "invalid card URL"},
+	}
+	got, failed := findFailedEntries(context.Background(), newFetchClient(), items)
+
+	titles := []string{}
+	for _, entry := range got {
+		titles = append(titles, entry.AuthorID+"/"+entry.TitleID)
+	}
+	want := []string{"000002/003", "000001/001", "000001/002"}
+	if !reflect.DeepEqual(want, titles) {
+		t.Errorf("want %v, but got %v", want, titles)
+	}
+	if got[0].SiteURL != ts.URL+"/cards/000002/card003.html" || got[0].ZipURL == "" {
+		t.Errorf("want entry found from the card page, but got %+v", got[0])
+	}
+
+	pages := []string{}
+	for _, e := range failed {
+		pages = append(pages, e.PageURL)
+	}
+	wantPages := []string{"card004", ts.URL + "/index_pages/person3.html"}
+	if !reflect.DeepEqual(wantPages, pages) {
+		t.Errorf("want %v, but got %v", wantPages, pages)
+	}
+	if failed[0].Err.Error() != "invalid card URL" {
+		t.Errorf("want the previous reason, but got %v", failed[0].Err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "fmt"
+
+// StatusError は HTTP のステータスコードが 200 以外だった場合のエラー
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: status code error: %d %s", e.URL, e.StatusCode, e.Status)
+}
+
+// NetworkError は HTTP の通信やレスポンスの読み込みに失敗した場合のエラー
+type NetworkError struct {
+	URL string
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("%s: %v", e.URL, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// ParseError はページの解析や必要な情報の抽出に失敗した場合のエラー
+type ParseError struct {
+	URL string
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: parse error: %v", e.URL, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// EntryError は作品ごとの情報の取得に失敗した場合のエラー
+//
+// findEntries は失敗した作品を読み飛ばし、このエラーとして報告する
+type EntryError struct {
+	PageURL string
+	Title   string
+	Err     error
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("%s (%s): %v", e.Title, e.PageURL, e.Err)
+}
+
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
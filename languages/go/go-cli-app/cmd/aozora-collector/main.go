@@ -1,165 +1,150 @@
 package main
 
 import (
-	"archive/zip"
-	"bytes"
-	"database/sql"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"path"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
-	"github.com/ikawaha/kagome-dict/ipa"
-	"github.com/ikawaha/kagome/v2/tokenizer"
-	_ "github.com/mattn/go-sqlite3"
-	"golang.org/x/text/encoding/japanese"
+	"github.com/yuichi04/aozora-search/aozora"
 )
 
-type Entry struct {
-	AuthorID string
-	Author   string
-	TitleID  string
-	Title    string
-	SiteURL  string
-	ZipURL   string
-}
-
-func setupDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dsn)
-	if err != nil {
-		return nil, err
-	}
-
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS authors(author_id TEXT, author TEXT, PRIMARY KEY (author_id))`,
-		`CREATE TABLE IF NOT EXISTS contents(author_id TEXT, title_id TEXT, title TEXT, content TEXT, PRIMARY KEY (author_id, title_id))`,
-		`CREATE VIRTUAL TABLE IF NOT EXISTS contents_fts USING fts4(words)`,
-	}
-	for _, query := range queries {
-		_, err = db.Exec(query)
-		if err != nil {
-			return nil, err
-		}
-	}
-	return db, nil
-}
-
-func addEntry(db *sql.DB, entry *Entry, content string) error {
-	_, err := db.Exec(`
-		REPLACE INTO authors(author_id, author) values(?, ?)
-	`,
-		entry.AuthorID,
-		entry.Author,
-	)
-	if err != nil {
-		return err
-	}
-
-	res, err := db.Exec(`
-		REPLACE INTO contents(author_id, title_id, title, content) values(?, ?, ?, ?)
-	`,
-		entry.AuthorID,
-		entry.TitleID,
-		entry.Title,
-		content,
-	)
-	if err != nil {
-		return err
-	}
-
-	docID, err := res.LastInsertId()
-	if err != nil {
-		return err
-	}
-
-	t, err := tokenizer.New(ipa.Dict(), tokenizer.OmitBosEos())
-	if err != nil {
-		return err
-	}
-
-	seg := t.Wakati(content)
-	_, err = db.Exec(`
-		REPLACE INTO contents_fts(docid, words) values(?, ?)
-	`,
-		docID,
-		strings.Join(seg, ""),
-	)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func getResopnseBody(url string) (*goquery.Document, error) {
+func getResopnseBody(ctx context.Context, client *Client, url string) (*goquery.Document, error) {
 	// URL から HTTP GET リクエストを実行
-	resp, err := http.Get(url)
+	resp, err := client.Get(ctx, url)
 	if err != nil {
-		log.Fatal(err)
+		return nil, &NetworkError{URL: url, Err: err}
 	}
 	defer resp.Body.Close()
 
 	// レスポンスのステータスコードをチェック
 	if resp.StatusCode != 200 {
-		log.Fatalf("status code error: %d %s", resp.StatusCode, resp.Status)
+		return nil, &StatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 
 	// レスポンスボディを goquery で解析
 	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return nil, &ParseError{URL: url, Err: err}
+	}
 
-	return doc, err
+	return doc, nil
 }
 
 var pageURLFormat = "https://www.aozora.gr.jp/cards/%s/card%s.html"
 
-func findEntries(siteURL string) ([]Entry, error) {
-	doc, err := getResopnseBody(siteURL)
+// cardPat は作品ページの URL から作者 ID と作品 ID を取り出す
+var cardPat = regexp.MustCompile(`.*/cards/([0-9]+)/card([0-9]+).html$`)
+
+// findEntries は作品一覧のページから作品を探す
+//
+// 作品ページの取得に失敗した作品は読み飛ばし、EntryError として返す。
+// 作品一覧のページ自体を取得できなかった場合はエラーを返す。
+// ctx がキャンセルされた場合は残りの作品ページを取得せずに ctx.Err() を返す。
+func findEntries(ctx context.Context, client *Client, siteURL string) ([]aozora.Entry, []*EntryError, error) {
+	doc, err := getResopnseBody(ctx, client, siteURL)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
 
-	// URL パターンをコンパイル
-	pat := regexp.MustCompile(`.*/cards/([0-9]+)/card([0-9]+).html$`)
-
-	entries := []Entry{}
+	entries := []aozora.Entry{}
+	failed := []*EntryError{}
 
 	// 解析したドキュメントを使用して、必要なデータを抽出
-	doc.Find("ol li a").Each(func(n int, elem *goquery.Selection) {
-		token := pat.FindStringSubmatch(elem.AttrOr("href", ""))
+	doc.Find("ol li a").EachWithBreak(func(n int, elem *goquery.Selection) bool {
+		token := cardPat.FindStringSubmatch(elem.AttrOr("href", ""))
 		if len(token) != 3 {
-			return
+			return true
 		}
 		title := elem.Text()
 		pageURL := fmt.Sprintf(pageURLFormat, token[1], token[2])
-		author, zipURL := findAuthorAndZIP(pageURL) // 作者とZIPファイルのURLを取得
-
-		if zipURL != "" {
-			entries = append(entries, Entry{
-				AuthorID: token[1],
-				Author:   author,
-				TitleID:  token[2],
-				Title:    title,
-				SiteURL:  siteURL,
-				ZipURL:   zipURL,
-			})
+		entry, err := findCard(ctx, client, pageURL) // 作者、ZIPファイルのURLと作品の詳細を取得
+		if ctx.Err() != nil {
+			// 中断による失敗は作品の失敗として記録しない
+			return false
+		}
+		if err != nil {
+			failed = append(failed, &EntryError{PageURL: pageURL, Title: title, Err: err})
+			return true
 		}
+
+		entry.AuthorID = token[1]
+		entry.TitleID = token[2]
+		entry.Title = title
+		entry.SiteURL = pageURL
+		entries = append(entries, *entry)
+		return true
 	})
 
-	return entries, nil
+	return entries, failed, ctx.Err()
+}
+
+// errZIPNotFound は作品ページに ZIP ファイルへのリンクが無い場合のエラー
+var errZIPNotFound = errors.New("zip file not found")
+
+// cardFields は作品ページの表の 見出し：値 の行を見出しから値を引けるようにする
+func cardFields(table *goquery.Selection) map[string]string {
+	fields := map[string]string{}
+	table.Find("tr").Each(func(n int, row *goquery.Selection) {
+		header := strings.TrimSpace(row.Find("td.header").Text())
+		if header == "" {
+			return
+		}
+		header = strings.TrimSuffix(header, "：")
+		fields[header] = strings.TrimSpace(row.Find("td").Not(".header").First().Text())
+	})
+	return fields
 }
 
-func findAuthorAndZIP(siteURL string) (string, string) {
-	doc, err := getResopnseBody(siteURL)
+// findCard は作品ページから作者、ZIP ファイルの URL と作品の詳細な情報を取得する
+//
+// 作者 ID、作品 ID、作品名は作品一覧のページから分かるので設定しない。
+func findCard(ctx context.Context, client *Client, siteURL string) (*aozora.Entry, error) {
+	doc, err := getResopnseBody(ctx, client, siteURL)
 	if err != nil {
-		return "", ""
+		return nil, err
 	}
 
-	author := doc.Find("table[summary=作家データ] tr:nth-child(1) td:nth-child(2)").Text()
+	entry := &aozora.Entry{}
+	title := cardFields(doc.Find("table[summary=タイトルデータ]"))
+	entry.TitleYomi = title["作品名読み"]
+	entry.Subtitle = title["副題"]
+	entry.OriginalTitle = title["原題"]
+
+	work := doc.Find("table[summary=作品データ]")
+	fields := cardFields(work)
+	entry.FirstAppearance = fields["初出"]
+	entry.CharType = fields["文字遣い種別"]
+	entry.Copyright = strings.Contains(work.Text(), "著作権存続")
+
+	// 作家データの表は人物ごとにあり、分類で著者と翻訳者などを区別する
+	translators := []string{}
+	doc.Find("table[summary=作家データ]").Each(func(n int, table *goquery.Selection) {
+		person := cardFields(table)
+		role, ok := person["分類"]
+		switch {
+		case role == "翻訳者":
+			translators = append(translators, person["作家名"])
+		case entry.Author == "" && (!ok || role == "著者"):
+			entry.Author = person["作家名"]
+			entry.AuthorYomi = person["作家名読み"]
+			entry.AuthorRomaji = person["ローマ字表記"]
+			entry.BirthDate = person["生年"]
+			entry.DeathDate = person["没年"]
+		}
+	})
+	entry.Translator = strings.Join(translators, ", ")
 
 	zipURL := ""
 	doc.Find("table.download a").Each(func(n int, elem *goquery.Selection) {
@@ -170,91 +155,196 @@ func findAuthorAndZIP(siteURL string) (string, string) {
 	})
 
 	if zipURL == "" {
-		return author, ""
+		return nil, &ParseError{URL: siteURL, Err: errZIPNotFound}
 	}
 
 	if strings.HasPrefix(zipURL, "http://") || strings.HasPrefix(zipURL, "https://") {
-		return author, zipURL
+		entry.ZipURL = zipURL
+		return entry, nil
 	}
 
 	u, err := url.Parse(siteURL)
 	if err != nil {
-		return author, ""
+		return nil, &ParseError{URL: siteURL, Err: err}
 	}
 
 	u.Path = path.Join(path.Dir(u.Path), zipURL)
-	return author, u.String()
+	entry.ZipURL = u.String()
+	return entry, nil
 }
 
-func extractText(zipURL string) (string, error) {
-	resp, err := http.Get(zipURL)
+func extractText(client *Client, zipURL string) (string, error) {
+	b, err := fetchZIP(context.Background(), client, zipURL)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
+	return decodeText(b)
+}
 
-	b, err := io.ReadAll(resp.Body)
+// fetchZIP は ZIP ファイルをダウンロードする
+func fetchZIP(ctx context.Context, client *Client, zipURL string) ([]byte, error) {
+	resp, err := fetchZIPIfModified(ctx, client, zipURL, nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return resp.data, nil
+}
+
+// zipResponse は条件付きリクエストでダウンロードした ZIP ファイル
+type zipResponse struct {
+	data         []byte
+	etag         string
+	lastModified string
+	notModified  bool // 304 Not Modified が返され、data は空
+}
 
-	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+// fetchZIPIfModified は prev の検証子を付けた条件付きリクエストで ZIP ファイルをダウンロードする
+//
+// prev が nil の場合は通常のリクエストを送る。
+func fetchZIPIfModified(ctx context.Context, client *Client, zipURL string, prev *aozora.FetchState) (*zipResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, zipURL, nil)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if prev != nil {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
 	}
 
-	for _, file := range r.File {
-		if path.Ext(file.Name) == ".txt" {
-			f, err := file.Open()
-			if err != nil {
-				return "", err
-			}
-
-			b, err := io.ReadAll(f)
-			f.Close()
-
-			if err != nil {
-				return "", err
-			}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &NetworkError{URL: zipURL, Err: err}
+	}
+	defer resp.Body.Close()
 
-			b, err = japanese.ShiftJIS.NewDecoder().Bytes(b)
-			if err != nil {
-				return "", err
-			}
+	r := &zipResponse{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}
+	if resp.StatusCode == http.StatusNotModified && prev != nil {
+		r.notModified = true
+		return r, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, &StatusError{URL: zipURL, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
 
-			return string(b), nil
-		}
+	r.data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &NetworkError{URL: zipURL, Err: err}
 	}
-	return "", errors.New("contents not found")
+	return r, nil
 }
 
 func main() {
-	db, err := setupDB("database.sqlite")
-	if err != nil {
-		log.Fatal(err)
+	workers := flag.Int("n", 4, "number of download workers")
+	batchSize := flag.Int("batch", 1, "number of entries committed per transaction")
+	all := flag.Bool("all", false, "crawl all authors from the author index pages")
+	force := flag.Bool("force", false, "download all entries even if they are unchanged since the last run")
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout of each HTTP request")
+	userAgent := flag.String("user-agent", defaultUserAgent, "User-Agent header sent to the server")
+	rate := flag.Float64("rate", 1, "maximum requests per second to each host (0 for no limit)")
+	retries := flag.Int("retries", 3, "number of retries on 429, 5xx and network errors")
+	cacheDir := flag.String("cache", "", "directory to save fetched pages and ZIP files in")
+	offline := flag.Bool("offline", false, "read pages and ZIP files only from the -cache directory")
+	resume := flag.Bool("resume", false, "resume the last interrupted crawl instead of finding entries again")
+	catalog := flag.String("catalog", "", "read entries from the CSV catalog file or URL instead of crawling (\"-\" for "+catalogURL+")")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s [AuthorID or list URL ...]:\n", os.Args[0])
+		flag.PrintDefaults()
 	}
-	defer db.Close()
+	flag.Parse()
 
-	listURL := "https://www.aozora.gr.jp/index_pages/person879.html"
+	client := NewClient(*userAgent, *timeout, *rate)
+	client.MaxRetries = *retries
+	if *cacheDir != "" {
+		client.Cache = NewCache(*cacheDir)
+	}
+	if *offline {
+		if *cacheDir == "" {
+			log.Fatal("-offline requires -cache")
+		}
+		client.Offline = true
+	}
 
-	entries, err := findEntries(listURL)
+	// Ctrl-C で収集を中断する
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	store, err := aozora.Open("database.sqlite")
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer store.Close()
+
+	var entries []aozora.Entry
+	skipped := []*EntryError{}
+	if *resume {
+		// 前回の収集で終わっていない作品と失敗した作品だけを集め直す
+		items, err := store.CrawlItems()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(items) == 0 {
+			log.Fatal("no crawl to resume")
+		}
+		pending, retry := pendingEntries(items)
 
-	log.Printf("found %d entries", len(entries))
+		// 作品を探す段階で取得できなかったページは探し直す
+		var found []aozora.Entry
+		found, skipped = findFailedEntries(ctx, client, retry)
+		if ctx.Err() != nil {
+			log.Fatal(ctx.Err())
+		}
+		items, found = resumeCrawl(items, found, skipped)
+		entries = append(pending, found...)
+		log.Printf("resuming %d entries (%d found again)", len(entries), len(found))
 
-	for _, entry := range entries {
-		content, err := extractText(entry.ZipURL)
+		err = store.StartCrawl(items)
 		if err != nil {
-			log.Println(err)
-			continue
+			log.Fatal(err)
 		}
-		err = addEntry(db, &entry, content)
+	} else {
+		source, err := newEntrySource(client, flag.Args(), *all, *catalog)
 		if err != nil {
-			log.Println(err)
-			continue
+			log.Fatal(err)
 		}
+		entries, skipped, err = source.Entries(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("found %d entries, skipped %d", len(entries), len(skipped))
+
+		// 取得できなかったページも失敗として記録し、-resume で探し直す
+		err = store.StartCrawl(crawlItems(entries, skipped))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// 前回の検証子とハッシュを使って、変わっていない作品を読み飛ばす
+	states := map[string]*aozora.FetchState{}
+	if !*force {
+		saved, err := store.FetchStates()
+		if err != nil {
+			log.Fatal(err)
+		}
+		states = fetchStateMap(saved)
+	}
+
+	// 中断した場合もそれまでに登録した作品はコミットする
+	batch := store.NewBatch(*batchSize)
+	stats, failed, err := collect(ctx, client, batch, store.Indexer(), entries, states, *workers, newProgress(os.Stderr, len(entries)))
+	if ferr := batch.Flush(); ferr != nil {
+		log.Fatal(ferr)
+	}
+	writeSummary(os.Stderr, stats, append(skipped, failed...))
+	if err != nil {
+		log.Fatal(err)
 	}
 }
 
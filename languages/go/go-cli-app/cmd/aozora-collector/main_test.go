@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"regexp"
 	"testing"
+	"time"
+
+	"github.com/yuichi04/aozora-search/aozora"
 )
 
+// newFetchClient はテストのサーバに流量の制限を掛けない Client を作る
+func newFetchClient() *Client {
+	c, _ := newTestClient(0)
+	return c
+}
+
 func TestFindEntries(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println(r.URL.String())
@@ -28,6 +39,10 @@ func TestFindEntries(t *testing.T) {
 		} else {
 			pat := regexp.MustCompile(`.*/cards/([0-9]+)/card([0-9]+).html$`)
 			token := pat.FindStringSubmatch(r.URL.String())
+			if token == nil {
+				http.NotFound(w, r)
+				return
+			}
 			w.Write([]byte(fmt.Sprintf(`
 			<table summary="作家データ">
 			<tr><td class="header">作家名：</td><td><font size="+2">テスト 太郎</font></td></tr>
@@ -50,36 +65,48 @@ func TestFindEntries(t *testing.T) {
 		pageURLFormat = tmp
 	}()
 
-	got, err := findEntries(ts.URL)
+	got, failed, err := findEntries(context.Background(), newFetchClient(), ts.URL)
 	if err != nil {
 		t.Error(err)
 		return
 	}
+	if len(failed) != 0 {
+		t.Errorf("want no failures, but got %v", failed)
+	}
 
-	want := []Entry{
+	want := []aozora.Entry{
 		{
 			AuthorID: "999999",
-			Author:   "テスト太郎",
+			Author:   "テスト 太郎",
 			TitleID:  "001",
-			Title:    "テスト書籍 001",
-			SiteURL:  ts.URL,
-			ZipURL:   ts.URL + "/cards/999999/files/000000_001.zip",
+			Title:    "テスト書籍001",
+			SiteURL:  ts.URL + "/cards/999999/card001.html",
+			ZipURL:   ts.URL + "/cards/999999/files/999999_001.zip",
+			// 作家データの表から作者の読みとローマ字表記も取得する
+			AuthorYomi:   "テスト 太郎",
+			AuthorRomaji: "Test, Taro",
 		},
 		{
 			AuthorID: "999999",
-			Author:   "テスト太郎",
+			Author:   "テスト 太郎",
 			TitleID:  "002",
-			Title:    "テスト書籍 002",
-			SiteURL:  ts.URL,
-			ZipURL:   ts.URL + "/cards/999999/files/000000_002.zip",
+			Title:    "テスト書籍002",
+			SiteURL:  ts.URL + "/cards/999999/card002.html",
+			ZipURL:   ts.URL + "/cards/999999/files/999999_002.zip",
+			// 作家データの表から作者の読みとローマ字表記も取得する
+			AuthorYomi:   "テスト 太郎",
+			AuthorRomaji: "Test, Taro",
 		},
 		{
 			AuthorID: "999999",
-			Author:   "テスト太郎",
+			Author:   "テスト 太郎",
 			TitleID:  "003",
-			Title:    "テスト書籍 003",
-			SiteURL:  ts.URL,
-			ZipURL:   ts.URL + "/cards/999999/files/000000_003.zip",
+			Title:    "テスト書籍003",
+			SiteURL:  ts.URL + "/cards/999999/card003.html",
+			ZipURL:   ts.URL + "/cards/999999/files/999999_003.zip",
+			// 作家データの表から作者の読みとローマ字表記も取得する
+			AuthorYomi:   "テスト 太郎",
+			AuthorRomaji: "Test, Taro",
 		},
 	}
 
@@ -88,11 +115,234 @@ func TestFindEntries(t *testing.T) {
 	}
 }
 
+func TestFindEntriesSkipsFailedPages(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.String() {
+		case "/":
+			w.Write([]byte(`
+			<ol>
+			<li><a href="../cards/999999/card001.html">テスト書籍001</a></li>
+			<li><a href="../cards/999999/card002.html">テスト書籍002</a></li>
+			<li><a href="../cards/999999/card003.html">テスト書籍003</a></li>
+			</ol>
+			`))
+		case "/cards/999999/card001.html":
+			w.Write([]byte(`
+			<table border="1" summary="ダウンロードデータ" class="download">
+			<tr><td><a href="./files/999999_001.zip">999999_001.zip</a></td></tr>
+			</table>
+			`))
+		case "/cards/999999/card002.html":
+			w.Write([]byte(`<p>no download</p>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	tmp := pageURLFormat
+	pageURLFormat = ts.URL + "/cards/%s/card%s.html"
+	defer func() {
+		pageURLFormat = tmp
+	}()
+
+	got, failed, err := findEntries(context.Background(), newFetchClient(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].TitleID != "001" {
+		t.Errorf("want only 001, but got %+v", got)
+	}
+	if len(failed) != 2 {
+		t.Fatalf("want 2 failures, but got %v", failed)
+	}
+
+	var parseErr *ParseError
+	if !errors.As(failed[0], &parseErr) || !errors.Is(parseErr, errZIPNotFound) {
+		t.Errorf("want ParseError, but got %v", failed[0])
+	}
+
+	var statusErr *StatusError
+	if !errors.As(failed[1], &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("want StatusError 404, but got %v", failed[1])
+	}
+}
+
+func TestFindEntriesListNotFound(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	_, _, err := findEntries(context.Background(), newFetchClient(), ts.URL)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Errorf("want StatusError, but got %v", err)
+	}
+}
+
+func TestFindEntriesCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := newFetchClient()
+
+	cards := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Write([]byte(`
+			<ol>
+			<li><a href="../cards/999999/card001.html">テスト書籍001</a></li>
+			<li><a href="../cards/999999/card002.html">テスト書籍002</a></li>
+			<li><a href="../cards/999999/card003.html">テスト書籍003</a></li>
+			</ol>
+			`))
+			return
+		}
+		// 最初の作品ページを取得している間に中断する
+		cards++
+		cancel()
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	tmp := pageURLFormat
+	pageURLFormat = ts.URL + "/cards/%s/card%s.html"
+	defer func() {
+		pageURLFormat = tmp
+	}()
+
+	got, failed, err := findEntries(ctx, client, ts.URL)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, but got %v", err)
+	}
+	if cards != 1 {
+		t.Errorf("want 1 card page fetched, but got %d", cards)
+	}
+	if len(got) != 0 || len(failed) != 0 {
+		t.Errorf("want no entries and no failures, but got %+v %+v", got, failed)
+	}
+
+	entries, failed := findAllEntries(ctx, client, []string{ts.URL, ts.URL})
+	if len(entries) != 0 || len(failed) != 0 {
+		t.Errorf("want no entries and no failures, but got %+v %+v", entries, failed)
+	}
+}
+
+func TestFindEntriesRetries(t *testing.T) {
+	failures := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Write([]byte("User-agent: *\nDisallow: /cards/999999/card002.html\n"))
+		case "/":
+			// 作品一覧のページは二回失敗してから返す
+			if failures < 2 {
+				failures++
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte(`
+			<ol>
+			<li><a href="../cards/999999/card001.html">テスト書籍001</a></li>
+			<li><a href="../cards/999999/card002.html">テスト書籍002</a></li>
+			</ol>
+			`))
+		default:
+			w.Write([]byte(`
+			<table border="1" summary="ダウンロードデータ" class="download">
+			<tr><td><a href="./files/999999_001.zip">999999_001.zip</a></td></tr>
+			</table>
+			`))
+		}
+	}))
+	defer ts.Close()
+
+	tmp := pageURLFormat
+	pageURLFormat = ts.URL + "/cards/%s/card%s.html"
+	defer func() {
+		pageURLFormat = tmp
+	}()
+
+	c, clock := newTestClient(0)
+	got, failed, err := findEntries(context.Background(), c, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].TitleID != "001" {
+		t.Errorf("want only 001, but got %+v", got)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second}
+	if !reflect.DeepEqual(want, clock.sleeps) {
+		t.Errorf("want sleeps %v, but got %v", want, clock.sleeps)
+	}
+
+	// robots.txt で禁止された作品ページは取得しない
+	if len(failed) != 1 || !errors.Is(failed[0], errDisallowed) {
+		t.Errorf("want failure disallowed by robots.txt, but got %v", failed)
+	}
+}
+
+func TestFindCard(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`
+		<table summary="タイトルデータ">
+		<tr><td class="header">作品名：</td><td><font size="+2"><strong>テスト書籍001</strong></font></td></tr>
+		<tr><td class="header">作品名読み：</td><td>てすとしょせき001</td></tr>
+		<tr><td class="header">副題：</td><td>試験の巻</td></tr>
+		<tr><td class="header">原題：</td><td>TEST BOOK</td></tr>
+		</table>
+		<table summary="作品データ">
+		<tr><td class="header">分類：</td><td>NDC 933</td></tr>
+		<tr><td class="header">初出：</td><td>「テスト」1915（大正4）年11月</td></tr>
+		<tr><td class="header">文字遣い種別：</td><td>新字新仮名</td></tr>
+		<tr><td class="header">備考：</td><td><font color="red">＊著作権存続＊</font></td></tr>
+		</table>
+		<table summary="作家データ">
+		<tr><td class="header">分類：</td><td>著者</td></tr>
+		<tr><td class="header">作家名：</td><td><font size="+2">テスト 太郎</font></td></tr>
+		<tr><td class="header">作家名読み：</td><td>てすと たろう</td></tr>
+		<tr><td class="header">ローマ字表記：</td><td>Test, Taro</td></tr>
+		<tr><td class="header">生年：</td><td>1892-03-01</td></tr>
+		<tr><td class="header">没年：</td><td>1927-07-24</td></tr>
+		</table>
+		<table summary="作家データ">
+		<tr><td class="header">分類：</td><td>翻訳者</td></tr>
+		<tr><td class="header">作家名：</td><td><font size="+2">テスト 花子</font></td></tr>
+		</table>
+		<table border="1" summary="ダウンロードデータ" class="download">
+		<tr><td><a href="./files/999999_001.zip">999999_001.zip</a></td></tr>
+		</table>
+		`))
+	}))
+	defer ts.Close()
+
+	got, err := findCard(context.Background(), newFetchClient(), ts.URL+"/cards/999999/card001.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &aozora.Entry{
+		Author:          "テスト 太郎",
+		ZipURL:          ts.URL + "/cards/999999/files/999999_001.zip",
+		AuthorYomi:      "てすと たろう",
+		AuthorRomaji:    "Test, Taro",
+		BirthDate:       "1892-03-01",
+		DeathDate:       "1927-07-24",
+		TitleYomi:       "てすとしょせき001",
+		Subtitle:        "試験の巻",
+		OriginalTitle:   "TEST BOOK",
+		FirstAppearance: "「テスト」1915（大正4）年11月",
+		CharType:        "新字新仮名",
+		Translator:      "テスト 花子",
+		Copyright:       true,
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %+v, but got %+v", want, got)
+	}
+}
+
 func TestExtractText(t *testing.T) {
 	ts := httptest.NewServer(http.FileServer(http.Dir(".")))
 	defer ts.Close()
 
-	got, err := extractText(ts.URL + "/testdata/example.zip")
+	got, err := extractText(newFetchClient(), ts.URL+"/testdata/example.zip")
 	if err != nil {
 		t.Fatal(err)
 		return
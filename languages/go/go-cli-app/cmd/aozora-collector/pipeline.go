@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/yuichi04/aozora-search/aozora"
+)
+
+// fetchStatus は前回の収集と比べた作品の状態
+type fetchStatus int
+
+const (
+	statusNew       fetchStatus = iota // 初めて収集した
+	statusUpdated                      // 前回から内容が変わった
+	statusUnchanged                    // 前回から内容が変わっていない
+)
+
+// fetched はダウンロードした ZIP ファイル
+type fetched struct {
+	entry  aozora.Entry
+	data   []byte // statusUnchanged の場合は空
+	state  *aozora.FetchState
+	status fetchStatus
+	err    error // ダウンロードに失敗した理由
+}
+
+// collected は登録する作品。本文が変わっていない場合や失敗した場合 doc は nil
+type collected struct {
+	entry  aozora.Entry
+	doc    *aozora.Document
+	state  *aozora.FetchState
+	status fetchStatus
+	err    error // ダウンロードか展開に失敗した理由
+}
+
+// collectStats は collect で処理した作品の数
+type collectStats struct {
+	New       int
+	Updated   int
+	Unchanged int
+	Failed    int
+}
+
+// documentStore は分かち書き済みの作品の登録先
+type documentStore interface {
+	AddDocument(doc *aozora.Document) error
+	SaveFetchState(state *aozora.FetchState) error
+	SetCrawlStatus(authorID, titleID string, status aozora.CrawlStatus, reason string) error
+}
+
+// entryKey は作品を区別するキー
+func entryKey(authorID, titleID string) string {
+	return authorID + "/" + titleID
+}
+
+// fetchStateMap は検証子とハッシュを作品ごとに引けるようにする
+func fetchStateMap(states []*aozora.FetchState) map[string]*aozora.FetchState {
+	m := map[string]*aozora.FetchState{}
+	for _, state := range states {
+		m[entryKey(state.AuthorID, state.TitleID)] = state
+	}
+	return m
+}
+
+// pendingEntries は記録した途中経過から、まだ収集していない作品と失敗した作品を返す
+//
+// 作品を探す段階で取得できなかったページは ZIP ファイルの URL が分からないので、
+// findFailedEntries で探し直すように retry として別に返す。
+func pendingEntries(items []aozora.CrawlItem) ([]aozora.Entry, []aozora.CrawlItem) {
+	entries := []aozora.Entry{}
+	retry := []aozora.CrawlItem{}
+	for _, item := range items {
+		switch {
+		case item.Status == aozora.CrawlDone:
+		case item.Entry.ZipURL == "":
+			retry = append(retry, item)
+		default:
+			entries = append(entries, item.Entry)
+		}
+	}
+	return entries, retry
+}
+
+// crawlItems は見つけた作品を未収集として、取得できなかったページを失敗として記録する項目にする
+func crawlItems(entries []aozora.Entry, failed []*EntryError) []aozora.CrawlItem {
+	items := []aozora.CrawlItem{}
+	for _, entry := range entries {
+		items = append(items, aozora.CrawlItem{Entry: entry, Status: aozora.CrawlPending})
+	}
+	for _, e := range failed {
+		// 作品ページなら作品 ID を、それ以外はページの URL を -resume で探し直すのに使う
+		entry := aozora.Entry{Title: e.Title, SiteURL: e.PageURL}
+		if token := cardPat.FindStringSubmatch(e.PageURL); len(token) == 3 {
+			entry.AuthorID = token[1]
+			entry.TitleID = token[2]
+		}
+		items = append(items, aozora.CrawlItem{Entry: entry, Status: aozora.CrawlFailed, Error: e.Err.Error()})
+	}
+	return items
+}
+
+// resumeCrawl は途中経過のうち作品を探す段階で失敗した項目を、探し直した結果に置き換える
+//
+// 途中経過に既にある作品は found から取り除き、残りを収集する作品として返す。
+func resumeCrawl(items []aozora.CrawlItem, found []aozora.Entry, failed []*EntryError) ([]aozora.CrawlItem, []aozora.Entry) {
+	next := []aozora.CrawlItem{}
+	seen := map[string]bool{}
+	for _, item := range items {
+		if item.Entry.ZipURL == "" {
+			continue
+		}
+		next = append(next, item)
+		seen[entryKey(item.Entry.AuthorID, item.Entry.TitleID)] = true
+	}
+
+	entries := []aozora.Entry{}
+	for _, entry := range found {
+		if seen[entryKey(entry.AuthorID, entry.TitleID)] {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return append(next, crawlItems(entries, failed)...), entries
+}
+
+// fetchEntry は states に前回の検証子があれば条件付きリクエストで ZIP ファイルを
+// ダウンロードし、前回と比べた作品の状態を調べる
+func fetchEntry(ctx context.Context, client *Client, entry aozora.Entry, states map[string]*aozora.FetchState) (*fetched, error) {
+	prev := states[entryKey(entry.AuthorID, entry.TitleID)]
+	validators := prev
+	if prev != nil && prev.ZipURL != entry.ZipURL {
+		// ZIP ファイルの URL が変わった場合は検証子を使えない
+		validators = nil
+	}
+	if client.Cache != nil && !client.Offline && !client.Cache.Has(entry.ZipURL) {
+		// 304 では内容を保存できないので、キャッシュに無い ZIP ファイルは全体を取得する
+		validators = nil
+	}
+
+	resp, err := fetchZIPIfModified(ctx, client, entry.ZipURL, validators)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &fetched{
+		entry: entry,
+		data:  resp.data,
+		state: &aozora.FetchState{
+			AuthorID:     entry.AuthorID,
+			TitleID:      entry.TitleID,
+			ZipURL:       entry.ZipURL,
+			ETag:         resp.etag,
+			LastModified: resp.lastModified,
+		},
+	}
+	if resp.notModified {
+		// 304 では検証子が省略されることがあるので、前回の値を引き継ぐ
+		if f.state.ETag == "" {
+			f.state.ETag = prev.ETag
+		}
+		if f.state.LastModified == "" {
+			f.state.LastModified = prev.LastModified
+		}
+		f.state.Hash = prev.Hash
+		f.status = statusUnchanged
+		return f, nil
+	}
+
+	f.state.Hash = hashHex(resp.data)
+	switch {
+	case prev == nil:
+		f.status = statusNew
+	case prev.Hash == f.state.Hash:
+		f.status = statusUnchanged
+		f.data = nil
+	default:
+		f.status = statusUpdated
+	}
+	return f, nil
+}
+
+// collect は entries の ZIP ファイルを並行にダウンロードし、store に登録する
+//
+// ダウンロード、展開と分かち書き、登録の三段階をチャネルでつなぐ。ダウンロードと
+// 分かち書きは workers 個の goroutine で並行に行い、ix はそれらで共有する。
+// SQLite への書き込みは一つの goroutine に限定する。
+// states に前回の検証子とハッシュがある作品は条件付きリクエストを送り、変わって
+// いなければ展開と分かち書きを省いて検証子だけを更新する。
+// 作品ごとの収集の状態を store に記録し、p が nil でなければ進み具合を表示する。
+// 失敗した作品は読み飛ばし、EntryError として返す。ctx がキャンセルされた場合は ctx.Err() を返す。
+func collect(ctx context.Context, client *Client, store documentStore, ix *aozora.Indexer, entries []aozora.Entry, states map[string]*aozora.FetchState, workers int, p *progress) (collectStats, []*EntryError, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan aozora.Entry)
+	go func() {
+		defer close(jobs)
+		for _, entry := range entries {
+			select {
+			case jobs <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	fetchedCh := make(chan *fetched)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range jobs {
+				f, err := fetchEntry(ctx, client, entry, states)
+				if err != nil {
+					f = &fetched{entry: entry, err: err}
+				}
+				select {
+				case fetchedCh <- f:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(fetchedCh)
+	}()
+
+	docs := make(chan collected)
+	var dwg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		dwg.Add(1)
+		go func() {
+			defer dwg.Done()
+			for f := range fetchedCh {
+				c := collected{entry: f.entry, state: f.state, status: f.status, err: f.err}
+				if c.err == nil && f.status != statusUnchanged {
+					z, err := decodeZIP(f.data)
+					if err != nil {
+						c.err = err
+					} else {
+						c.doc = ix.Document(&f.entry, z.Text)
+						c.doc.Images = z.Images
+						c.doc.Fetch = f.state
+					}
+				}
+				select {
+				case docs <- c:
+				case <-ctx.Done():
+					// 上流の goroutine を止めるために残りを読み捨てる
+					for range fetchedCh {
+					}
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		dwg.Wait()
+		close(docs)
+	}()
+
+	// SQLite は並行な書き込みに弱いので、登録と状態の記録はこの goroutine だけで行う
+	stats := collectStats{}
+	failed := []*EntryError{}
+	done := 0
+	for c := range docs {
+		if ctx.Err() != nil {
+			continue
+		}
+		err := c.err
+		if err == nil {
+			err = save(store, c)
+		}
+		if err != nil {
+			// 失敗は進み具合の表示を崩さないように、最後にまとめて書き出す
+			serr := store.SetCrawlStatus(c.entry.AuthorID, c.entry.TitleID, aozora.CrawlFailed, err.Error())
+			failed = append(failed, &EntryError{PageURL: c.entry.ZipURL, Title: c.entry.Title, Err: errors.Join(err, serr)})
+			stats.Failed++
+		} else {
+			switch c.status {
+			case statusNew:
+				stats.New++
+			case statusUpdated:
+				stats.Updated++
+			case statusUnchanged:
+				stats.Unchanged++
+			}
+		}
+
+		done++
+		if p != nil {
+			p.update(done)
+		}
+	}
+	if p != nil {
+		p.finish(done)
+	}
+	return stats, failed, ctx.Err()
+}
+
+// save は作品を登録し、収集済みとして記録する
+//
+// 状態を先に記録しておくと、Batch では作品と同じトランザクションでコミットされる。
+func save(store documentStore, c collected) error {
+	err := store.SetCrawlStatus(c.entry.AuthorID, c.entry.TitleID, aozora.CrawlDone, "")
+	if err != nil {
+		return err
+	}
+	if c.doc != nil {
+		return store.AddDocument(c.doc)
+	}
+	return store.SaveFetchState(c.state)
+}
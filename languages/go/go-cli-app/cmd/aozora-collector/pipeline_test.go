@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/yuichi04/aozora-search/aozora"
+)
+
+// recordStore は AddDocument の呼び出しを記録する documentStore
+type recordStore struct {
+	running  int32
+	parallel bool
+	titles   []string
+	contents []string
+}
+
+func (s *recordStore) AddDocument(doc *aozora.Document) error {
+	if atomic.AddInt32(&s.running, 1) > 1 {
+		s.parallel = true
+	}
+	defer atomic.AddInt32(&s.running, -1)
+
+	s.titles = append(s.titles, doc.Entry.TitleID)
+	s.contents = append(s.contents, doc.Content)
+	return nil
+}
+
+func (s *recordStore) SaveFetchState(state *aozora.FetchState) error {
+	return nil
+}
+
+func (s *recordStore) SetCrawlStatus(authorID, titleID string, status aozora.CrawlStatus, reason string) error {
+	return nil
+}
+
+func TestCollect(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.Dir(".")))
+	defer ts.Close()
+
+	entries := []aozora.Entry{
+		{AuthorID: "999999", TitleID: "001", ZipURL: ts.URL + "/testdata/example.zip"},
+		{AuthorID: "999999", TitleID: "002", ZipURL: ts.URL + "/testdata/example.zip"},
+		{AuthorID: "999999", TitleID: "003", ZipURL: ts.URL + "/testdata/notfound.zip"},
+		{AuthorID: "999999", TitleID: "004", ZipURL: ts.URL + "/testdata/example.zip"},
+	}
+
+	ix, err := aozora.NewIndexer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &recordStore{}
+	var out bytes.Buffer
+	stats, failed, err := collect(context.Background(), newFetchClient(), store, ix, entries, nil, 3, newProgress(&out, len(entries)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if store.parallel {
+		t.Error("AddDocument must not be called concurrently")
+	}
+	wantStats := collectStats{New: 3, Failed: 1}
+	if stats != wantStats {
+		t.Errorf("want %+v, but got %+v", wantStats, stats)
+	}
+	var statusErr *StatusError
+	if len(failed) != 1 || !errors.As(failed[0], &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("want failure with 404, but got %v", failed)
+	}
+	if !strings.Contains(out.String(), "4/4 entries") {
+		t.Errorf("want progress of 4/4 entries, but got %q", out.String())
+	}
+
+	sort.Strings(store.titles)
+	want := []string{"001", "002", "004"}
+	if len(store.titles) != len(want) {
+		t.Fatalf("want %v, but got %v", want, store.titles)
+	}
+	for i := range want {
+		if store.titles[i] != want[i] {
+			t.Errorf("want %v, but got %v", want, store.titles)
+		}
+		if store.contents[i] != "テストデータ\n" {
+			t.Errorf("want %q, but got %q", "テストデータ\n", store.contents[i])
+		}
+	}
+}
+
+func TestCollectCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.Dir(".")))
+	defer ts.Close()
+
+	entries := []aozora.Entry{
+		{AuthorID: "999999", TitleID: "001", ZipURL: ts.URL + "/testdata/example.zip"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ix, err := aozora.NewIndexer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &recordStore{}
+	_, _, err = collect(ctx, newFetchClient(), store, ix, entries, nil, 1, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, but got %v", err)
+	}
+	if len(store.titles) != 0 {
+		t.Errorf("want no entries, but got %v", store.titles)
+	}
+}
+
+func TestCollectIncremental(t *testing.T) {
+	example, err := os.ReadFile("testdata/example.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := os.ReadFile("testdata/utf8.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// /etag/ 以下は ETag を返して条件付きリクエストに 304 を返し、/plain/ 以下は検証子を返さない
+	var mu sync.Mutex
+	files := map[string][]byte{
+		"/etag/001.zip":  example,
+		"/etag/002.zip":  example,
+		"/plain/003.zip": example,
+	}
+	notModified := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		b, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/etag/") {
+			etag := fmt.Sprintf(`"%x"`, sha256.Sum256(b))
+			if r.Header.Get("If-None-Match") == etag {
+				notModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+		}
+		w.Write(b)
+	}))
+	defer ts.Close()
+
+	entries := []aozora.Entry{
+		{AuthorID: "999999", TitleID: "001", Title: "テスト書籍001", ZipURL: ts.URL + "/etag/001.zip"},
+		{AuthorID: "999999", TitleID: "002", Title: "テスト書籍002", ZipURL: ts.URL + "/etag/002.zip"},
+		{AuthorID: "999999", TitleID: "003", Title: "テスト書籍003", ZipURL: ts.URL + "/plain/003.zip"},
+	}
+
+	store, err := aozora.Open(filepath.Join(t.TempDir(), "database.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	run := func() collectStats {
+		t.Helper()
+		saved, err := store.FetchStates()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats, _, err := collect(context.Background(), newFetchClient(), store, store.Indexer(), entries, fetchStateMap(saved), 2, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+
+	if got, want := run(), (collectStats{New: 3}); got != want {
+		t.Errorf("first run: want %+v, but got %+v", want, got)
+	}
+
+	// 検証子のある作品は 304、無い作品はハッシュで変わっていないと分かる
+	if got, want := run(), (collectStats{Unchanged: 3}); got != want {
+		t.Errorf("second run: want %+v, but got %+v", want, got)
+	}
+	if notModified != 2 {
+		t.Errorf("want 2 responses with 304, but got %d", notModified)
+	}
+
+	mu.Lock()
+	files["/etag/002.zip"] = updated
+	mu.Unlock()
+	if got, want := run(), (collectStats{Updated: 1, Unchanged: 2}); got != want {
+		t.Errorf("third run: want %+v, but got %+v", want, got)
+	}
+	content, err := store.Content("999999", "002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content == "テストデータ\n" {
+		t.Errorf("want updated content, but got %q", content)
+	}
+}
+
+func TestCollectIncrementalCache(t *testing.T) {
+	example, err := os.ReadFile("testdata/example.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(example))
+	var notModified int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(example)
+	}))
+
+	entries := []aozora.Entry{
+		{AuthorID: "999999", TitleID: "001", Title: "テスト書籍001", ZipURL: ts.URL + "/001.zip"},
+		{AuthorID: "999999", TitleID: "002", Title: "テスト書籍002", ZipURL: ts.URL + "/002.zip"},
+	}
+
+	store, err := aozora.Open(filepath.Join(t.TempDir(), "database.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	run := func(client *Client, store *aozora.SQLiteStore, incremental bool) collectStats {
+		t.Helper()
+		states := map[string]*aozora.FetchState{}
+		if incremental {
+			saved, err := store.FetchStates()
+			if err != nil {
+				t.Fatal(err)
+			}
+			states = fetchStateMap(saved)
+		}
+		stats, failed, err := collect(context.Background(), client, store, store.Indexer(), entries, states, 2, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(failed) != 0 {
+			t.Errorf("want no failures, but got %v", failed)
+		}
+		return stats
+	}
+
+	// キャッシュを使わずに収集した後、-cache を付けて差分を収集する
+	run(newFetchClient(), store, true)
+	cache := NewCache(t.TempDir())
+	client := newFetchClient()
+	client.Cache = cache
+	if got, want := run(client, store, true), (collectStats{Unchanged: 2}); got != want {
+		t.Errorf("cached run: want %+v, but got %+v", want, got)
+	}
+	// キャッシュに無い ZIP ファイルには検証子を送らずに全体を取得する
+	if n := atomic.LoadInt32(&notModified); n != 0 {
+		t.Errorf("want no 304 responses, but got %d", n)
+	}
+
+	// サーバを止めても、キャッシュから別のデータベースを作り直せる
+	ts.Close()
+	offline := newFetchClient()
+	offline.Cache = cache
+	offline.Offline = true
+	rebuilt, err := aozora.Open(filepath.Join(t.TempDir(), "database.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rebuilt.Close()
+	if got, want := run(offline, rebuilt, false), (collectStats{New: 2}); got != want {
+		t.Errorf("offline run: want %+v, but got %+v", want, got)
+	}
+}
+
+func TestCollectResume(t *testing.T) {
+	var mu sync.Mutex
+	broken := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/002.zip" && broken {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.ServeFile(w, r, "testdata/example.zip")
+	}))
+	defer ts.Close()
+
+	entries := []aozora.Entry{
+		{AuthorID: "999999", TitleID: "001", Title: "テスト書籍001", ZipURL: ts.URL + "/001.zip"},
+		{AuthorID: "999999", TitleID: "002", Title: "テスト書籍002", ZipURL: ts.URL + "/002.zip"},
+		{AuthorID: "999999", TitleID: "003", Title: "テスト書籍003", ZipURL: ts.URL + "/003.zip"},
+	}
+
+	store, err := aozora.Open(filepath.Join(t.TempDir(), "database.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	err = store.StartCrawl(crawlItems(entries, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := store.NewBatch(10)
+	_, _, err = collect(context.Background(), newFetchClient(), batch, store.Indexer(), entries, nil, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = batch.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := store.CrawlItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []aozora.CrawlStatus{}
+	for _, item := range items {
+		statuses = append(statuses, item.Status)
+	}
+	want := []aozora.CrawlStatus{aozora.CrawlDone, aozora.CrawlFailed, aozora.CrawlDone}
+	if !reflect.DeepEqual(want, statuses) {
+		t.Errorf("want %v, but got %v", want, statuses)
+	}
+	if !strings.Contains(items[1].Error, "403") {
+		t.Errorf("want reason with 403, but got %q", items[1].Error)
+	}
+
+	// 再開すると失敗した作品だけを集め直す
+	pending, retry := pendingEntries(items)
+	if len(pending) != 1 || pending[0].TitleID != "002" || len(retry) != 0 {
+		t.Fatalf("want only 002, but got %+v, %+v", pending, retry)
+	}
+	mu.Lock()
+	broken = false
+	mu.Unlock()
+	stats, _, err := collect(context.Background(), newFetchClient(), store, store.Indexer(), pending, nil, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (collectStats{New: 1}) {
+		t.Errorf("want one new entry, but got %+v", stats)
+	}
+	items, err = store.CrawlItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items[1].Status != aozora.CrawlDone || items[1].Entry.Title != "テスト書籍002" {
+		t.Errorf("want 002 done, but got %+v", items[1])
+	}
+}
+
+func TestResumeCrawl(t *testing.T) {
+	store, err := aozora.Open(filepath.Join(t.TempDir(), "database.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	entries := []aozora.Entry{
+		{AuthorID: "000001", TitleID: "001", Title: "テスト書籍001", ZipURL: "https://example.com/001.zip"},
+	}
+	skipped := []*EntryError{
+		{PageURL: "https://example.com/cards/000001/card002.html", Title: "テスト書籍002", Err: errors.New("503 Service Unavailable")},
+		{PageURL: "https://example.com/index_pages/person2.html", Title: "作品一覧", Err: errors.New("404 Not Found")},
+		{PageURL: "https://example.com/index_pages/person3.html", Title: "作品一覧", Err: errors.New("404 Not Found")},
+	}
+	err = store.StartCrawl(crawlItems(entries, skipped))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 作品を探す段階で失敗したページも失敗として記録し、探し直す対象にする
+	items, err := store.CrawlItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []aozora.CrawlStatus{}
+	for _, item := range items {
+		statuses = append(statuses, item.Status)
+	}
+	want := []aozora.CrawlStatus{aozora.CrawlPending, aozora.CrawlFailed, aozora.CrawlFailed, aozora.CrawlFailed}
+	if !reflect.DeepEqual(want, statuses) {
+		t.Fatalf("want %v, but got %v", want, statuses)
+	}
+	pending, retry := pendingEntries(items)
+	if len(pending) != 1 || len(retry) != 3 {
+		t.Fatalf("want 1 pending and 3 retries, but got %+v, %+v", pending, retry)
+	}
+	if retry[0].Entry.AuthorID != "000001" || retry[0].Entry.TitleID != "002" || retry[0].Error != "503 Service Unavailable" {
+		t.Errorf("want card 000001/002, but got %+v", retry[0])
+	}
+
+	// 見つかった作品は未収集に、まだ取得できないページは失敗のまま残す
+	found := []aozora.Entry{
+		{AuthorID: "000001", TitleID: "001", Title: "テスト書籍001", ZipURL: "https://example.com/001.zip"},
+		{AuthorID: "000001", TitleID: "002", Title: "テスト書籍002", ZipURL: "https://example.com/002.zip"},
+		{AuthorID: "000002", TitleID: "003", Title: "テスト書籍003", ZipURL: "https://example.com/003.zip"},
+	}
+	items, found = resumeCrawl(items, found, skipped[2:])
+	if len(found) != 2 || found[0].TitleID != "002" || found[1].TitleID != "003" {
+		t.Errorf("want 002 and 003, but got %+v", found)
+	}
+	err = store.StartCrawl(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err = store.CrawlItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, item := range items {
+		got = append(got, fmt.Sprintf("%s/%s %s", item.Entry.AuthorID, item.Entry.TitleID, item.Status))
+	}
+	wantItems := []string{"000001/001 pending", "000001/002 pending", "000002/003 pending", "/ failed"}
+	if !reflect.DeepEqual(wantItems, got) {
+		t.Errorf("want %v, but got %v", wantItems, got)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// progress は収集の進み具合を一行で表示し、書き換えていく
+type progress struct {
+	w        io.Writer
+	total    int
+	interval time.Duration // 表示を書き換える間隔
+	now      func() time.Time
+	start    time.Time
+	last     time.Time
+}
+
+// newProgress は total 件の作品の進み具合を w に表示する progress を作る
+func newProgress(w io.Writer, total int) *progress {
+	now := time.Now()
+	return &progress{
+		w:        w,
+		total:    total,
+		interval: time.Second,
+		now:      time.Now,
+		start:    now,
+	}
+}
+
+// update は done 件が終わったことを表示する。表示は interval ごとにしか書き換えない
+func (p *progress) update(done int) {
+	now := p.now()
+	if now.Sub(p.last) < p.interval && done < p.total {
+		return
+	}
+	p.last = now
+	fmt.Fprintf(p.w, "\r%s", formatProgress(done, p.total, now.Sub(p.start)))
+}
+
+// finish は最後の進み具合を表示して改行する
+func (p *progress) finish(done int) {
+	fmt.Fprintf(p.w, "\r%s\n", formatProgress(done, p.total, p.now().Sub(p.start)))
+}
+
+// formatProgress は 12/100 entries (2.0/s, ETA 44s) の形で進み具合を返す
+func formatProgress(done, total int, elapsed time.Duration) string {
+	if done == 0 || elapsed <= 0 {
+		return fmt.Sprintf("%d/%d entries (ETA --)", done, total)
+	}
+	rate := float64(done) / elapsed.Seconds()
+	eta := time.Duration(float64(total-done) / rate * float64(time.Second)).Round(time.Second)
+	return fmt.Sprintf("%d/%d entries (%.1f/s, ETA %s)", done, total, rate, eta)
+}
+
+// writeSummary は収集した作品の数と、失敗した作品とその理由を書き出す
+func writeSummary(w io.Writer, stats collectStats, failed []*EntryError) {
+	fmt.Fprintf(w, "new %d, updated %d, unchanged %d, failed %d\n", stats.New, stats.Updated, stats.Unchanged, stats.Failed)
+	if len(failed) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%d failures:\n", len(failed))
+	for _, e := range failed {
+		fmt.Fprintf(w, "  %v\n", e)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatProgress(t *testing.T) {
+	tests := []struct {
+		done    int
+		total   int
+		elapsed time.Duration
+		want    string
+	}{
+		{done: 0, total: 100, elapsed: 0, want: "0/100 entries (ETA --)"},
+		{done: 10, total: 100, elapsed: 5 * time.Second, want: "10/100 entries (2.0/s, ETA 45s)"},
+		{done: 100, total: 100, elapsed: time.Minute, want: "100/100 entries (1.7/s, ETA 0s)"},
+	}
+	for _, tt := range tests {
+		got := formatProgress(tt.done, tt.total, tt.elapsed)
+		if got != tt.want {
+			t.Errorf("want %q, but got %q", tt.want, got)
+		}
+	}
+}
+
+func TestProgressUpdate(t *testing.T) {
+	var out bytes.Buffer
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := newProgress(&out, 3)
+	p.now = func() time.Time { return now }
+	p.start = now
+
+	// interval が経たないうちは書き換えない
+	now = now.Add(2 * time.Second)
+	p.update(1)
+	now = now.Add(100 * time.Millisecond)
+	p.update(2)
+	now = now.Add(time.Second)
+	p.finish(3)
+
+	want := "\r1/3 entries (0.5/s, ETA 4s)" + "\r3/3 entries (1.0/s, ETA 0s)\n"
+	if out.String() != want {
+		t.Errorf("want %q, but got %q", want, out.String())
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	var out bytes.Buffer
+	writeSummary(&out, collectStats{New: 2, Failed: 1}, []*EntryError{
+		{PageURL: "https://example.com/1.zip", Title: "テスト書籍001", Err: errors.New("zip file not found")},
+	})
+
+	want := strings.Join([]string{
+		"new 2, updated 0, unchanged 0, failed 1",
+		"1 failures:",
+		"  テスト書籍001 (https://example.com/1.zip): zip file not found",
+		"",
+	}, "\n")
+	if out.String() != want {
+		t.Errorf("want %q, but got %q", want, out.String())
+	}
+}
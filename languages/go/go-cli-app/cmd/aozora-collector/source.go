@@ -0,0 +1,255 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/yuichi04/aozora-search/aozora"
+)
+
+// EntrySource は収集する作品の一覧の取得元
+type EntrySource interface {
+	// Entries は作品の一覧を返す。取得できなかった作品は読み飛ばし、EntryError として返す
+	Entries(ctx context.Context) ([]aozora.Entry, []*EntryError, error)
+}
+
+// newEntrySource はコマンドライン引数から作品の一覧の取得元を作る
+//
+// 引数には作者 ID または作品一覧の URL を指定する。catalog を指定した場合は
+// CSV カタログを使い、引数の作者 ID で作品を絞り込む。ページは client で取得する。
+func newEntrySource(client *Client, args []string, all bool, catalog string) (EntrySource, error) {
+	if catalog != "" {
+		if catalog == "-" {
+			catalog = catalogURL
+		}
+		return &catalogSource{client: client, location: catalog, authorIDs: args}, nil
+	}
+
+	listURLs := []string{}
+	for _, arg := range args {
+		u, err := listURL(arg)
+		if err != nil {
+			return nil, err
+		}
+		listURLs = append(listURLs, u)
+	}
+	if len(listURLs) == 0 && !all {
+		// 引数が無い場合は芥川竜之介の作品を集める
+		listURLs = append(listURLs, fmt.Sprintf(listURLFormat, "879"))
+	}
+	return &htmlSource{client: client, listURLs: listURLs, all: all}, nil
+}
+
+// htmlSource は作品一覧のページと作品ページを巡回して作品を集める
+type htmlSource struct {
+	client   *Client
+	listURLs []string
+	all      bool // 作家索引のページから全ての作者を探す
+}
+
+func (s *htmlSource) Entries(ctx context.Context) ([]aozora.Entry, []*EntryError, error) {
+	listURLs := s.listURLs
+	failed := []*EntryError{}
+	if s.all {
+		found, skipped := findAllAuthors(ctx, s.client)
+		failed = append(failed, skipped...)
+		listURLs = append(listURLs, found...)
+	}
+
+	entries, skipped := findAllEntries(ctx, s.client, listURLs)
+	failed = append(failed, skipped...)
+	return entries, failed, ctx.Err()
+}
+
+// catalogURL は青空文庫の公開中の作品の CSV カタログ
+const catalogURL = "https://www.aozora.gr.jp/index_pages/list_person_all_extended_utf8.zip"
+
+// catalogSource は青空文庫の CSV カタログから作品を集める
+type catalogSource struct {
+	client    *Client
+	location  string   // CSV または ZIP のファイル名か URL
+	authorIDs []string // 空でなければこの作者の作品だけを集める
+}
+
+func (s *catalogSource) Entries(ctx context.Context) ([]aozora.Entry, []*EntryError, error) {
+	var b []byte
+	var err error
+	if strings.HasPrefix(s.location, "http://") || strings.HasPrefix(s.location, "https://") {
+		b, err = fetchZIP(ctx, s.client, s.location)
+	} else {
+		b, err = os.ReadFile(s.location)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r, err := catalogReader(b)
+	if err != nil {
+		return nil, nil, &ParseError{URL: s.location, Err: err}
+	}
+
+	entries, failed, err := readCatalog(r, s.authorIDs)
+	if err != nil {
+		return nil, nil, &ParseError{URL: s.location, Err: err}
+	}
+	return entries, failed, nil
+}
+
+// catalogReader は CSV カタログを読み出す。ZIP の場合は中の CSV ファイルを読み出す
+func catalogReader(b []byte) (io.Reader, error) {
+	if !bytes.HasPrefix(b, []byte("PK")) {
+		return bytes.NewReader(b), nil
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range r.File {
+		if path.Ext(file.Name) != ".csv" {
+			continue
+		}
+		f, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(b), nil
+	}
+	return nil, errors.New("csv file not found")
+}
+
+// catalogColumns は CSV カタログで使う列の名前
+var catalogColumns = []string{
+	"作品ID", "作品名", "作品名読み", "ソート用読み", "副題", "原題", "初出", "文字遣い種別",
+	"作品著作権フラグ", "公開日", "図書カードURL", "人物ID", "姓", "名", "姓読み", "名読み",
+	"姓ローマ字", "名ローマ字", "役割フラグ", "生年月日", "没年月日", "テキストファイルURL",
+}
+
+// readCatalog は CSV カタログを読んで作品の一覧を返す
+//
+// カタログは作品と人物の組ごとに一行なので、著者の行から Entry を作り、
+// 翻訳者の行は Translator に入れる。
+func readCatalog(r io.Reader, authorIDs []string) ([]aozora.Entry, []*EntryError, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.TrimPrefix(name, "\ufeff")] = i
+	}
+	for _, name := range catalogColumns {
+		if _, ok := col[name]; !ok {
+			return nil, nil, fmt.Errorf("column %q not found", name)
+		}
+	}
+
+	wanted := map[int]bool{}
+	for _, id := range authorIDs {
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid author ID: %q", id)
+		}
+		wanted[n] = true
+	}
+
+	entries := []aozora.Entry{}
+	failed := []*EntryError{}
+	index := map[string]int{} // 作品 ID から entries の添字
+	translators := map[string][]string{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		field := func(name string) string {
+			i := col[name]
+			if i >= len(record) {
+				return ""
+			}
+			return record[i]
+		}
+
+		workID := field("作品ID")
+		name := strings.TrimSpace(field("姓") + " " + field("名"))
+		switch field("役割フラグ") {
+		case "翻訳者":
+			translators[workID] = append(translators[workID], name)
+			continue
+		case "著者":
+		default:
+			continue
+		}
+
+		if len(wanted) > 0 {
+			n, _ := strconv.Atoi(field("人物ID"))
+			if !wanted[n] {
+				continue
+			}
+		}
+		if _, ok := index[workID]; ok {
+			continue
+		}
+
+		pageURL := field("図書カードURL")
+		token := cardPat.FindStringSubmatch(pageURL)
+		if len(token) != 3 {
+			failed = append(failed, &EntryError{PageURL: pageURL, Title: field("作品名"), Err: errors.New("invalid card URL")})
+			continue
+		}
+		zipURL := field("テキストファイルURL")
+		if !strings.HasSuffix(zipURL, ".zip") {
+			failed = append(failed, &EntryError{PageURL: pageURL, Title: field("作品名"), Err: errZIPNotFound})
+			continue
+		}
+
+		index[workID] = len(entries)
+		entries = append(entries, aozora.Entry{
+			AuthorID:        token[1],
+			Author:          name,
+			TitleID:         token[2],
+			Title:           field("作品名"),
+			SiteURL:         pageURL,
+			ZipURL:          zipURL,
+			AuthorYomi:      strings.TrimSpace(field("姓読み") + " " + field("名読み")),
+			AuthorRomaji:    strings.TrimSpace(field("名ローマ字") + " " + field("姓ローマ字")),
+			BirthDate:       field("生年月日"),
+			DeathDate:       field("没年月日"),
+			TitleYomi:       field("作品名読み"),
+			TitleSort:       field("ソート用読み"),
+			Subtitle:        field("副題"),
+			OriginalTitle:   field("原題"),
+			FirstAppearance: field("初出"),
+			CharType:        field("文字遣い種別"),
+			Copyright:       field("作品著作権フラグ") == "あり",
+			ReleaseDate:     field("公開日"),
+		})
+	}
+
+	for workID, names := range translators {
+		if i, ok := index[workID]; ok {
+			entries[i].Translator = strings.Join(names, ", ")
+		}
+	}
+	return entries, failed, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/yuichi04/aozora-search/aozora"
+)
+
+func TestCatalogSource(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.Dir(".")))
+	defer ts.Close()
+
+	want := []aozora.Entry{
+		{
+			AuthorID:        "000879",
+			Author:          "芥川 竜之介",
+			TitleID:         "14",
+			Title:           "あばばばば",
+			SiteURL:         "https://www.aozora.gr.jp/cards/000879/card14.html",
+			ZipURL:          "https://www.aozora.gr.jp/cards/000879/files/14_ruby_5570.zip",
+			AuthorYomi:      "あくたがわ りゅうのすけ",
+			AuthorRomaji:    "Ryunosuke Akutagawa",
+			BirthDate:       "1892-03-01",
+			DeathDate:       "1927-07-24",
+			TitleYomi:       "あばばばば",
+			TitleSort:       "あはははは",
+			FirstAppearance: "「中央公論」1923（大正12）年12月",
+			CharType:        "旧字旧仮名",
+			ReleaseDate:     "1999-01-01",
+		},
+		{
+			AuthorID:      "999999",
+			Author:        "テスト 太郎",
+			TitleID:       "100",
+			Title:         "テスト翻訳",
+			SiteURL:       "https://www.aozora.gr.jp/cards/999999/card100.html",
+			ZipURL:        "https://www.aozora.gr.jp/cards/999999/files/100_ruby.zip",
+			Subtitle:      "副題",
+			OriginalTitle: "Test",
+			CharType:      "新字新仮名",
+			Translator:    "翻訳 花子",
+			Copyright:     true,
+		},
+	}
+
+	for _, location := range []string{"testdata/catalog.csv", ts.URL + "/testdata/catalog.zip"} {
+		source := &catalogSource{client: newFetchClient(), location: location}
+		got, failed, err := source.Entries(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: want %+v, but got %+v", location, want, got)
+		}
+
+		// テキストファイルの無い作品は読み飛ばす
+		if len(failed) != 1 || !errors.Is(failed[0], errZIPNotFound) {
+			t.Errorf("%s: want 1 failure, but got %v", location, failed)
+		}
+	}
+}
+
+func TestCatalogSourceAuthorIDs(t *testing.T) {
+	source := &catalogSource{client: newFetchClient(), location: "testdata/catalog.csv", authorIDs: []string{"879"}}
+	got, _, err := source.Entries(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].TitleID != "14" {
+		t.Errorf("want only 14, but got %+v", got)
+	}
+}
+
+func TestHTMLSource(t *testing.T) {
+	newAozoraServer(t)
+
+	source, err := newEntrySource(newFetchClient(), nil, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := source.Entries(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Errorf("want 3 entries, but got %+v", got)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuichi04/aozora-search/aozora"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// テキストファイルの文字コード
+const (
+	encodingShiftJIS     = "Shift_JIS" // CP932 (Windows-31J) として読む
+	encodingShiftJIS2004 = "Shift_JIS-2004"
+	encodingUTF8         = "UTF-8"
+	encodingUTF8BOM      = "UTF-8 BOM"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// imageExts は作品と一緒に保存する挿絵の拡張子
+var imageExts = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true}
+
+// zipContent は ZIP ファイルから取り出した作品
+type zipContent struct {
+	Text      string         // テキストファイルを名前順に連結した本文
+	Files     []string       // 本文に使ったテキストファイル
+	Encodings []string       // それぞれのテキストファイルの文字コード
+	Images    []aozora.Image // 挿絵
+}
+
+// decodeText は ZIP ファイルの中からテキストを取り出す
+func decodeText(b []byte) (string, error) {
+	z, err := decodeZIP(b)
+	if err != nil {
+		return "", err
+	}
+	return z.Text, nil
+}
+
+// decodeZIP は ZIP ファイルの中からテキストと挿絵を取り出す
+//
+// テキストファイルが複数ある場合は名前順に連結する。テキストファイルの
+// 文字コードは BOM の有無と UTF-8 として正しいかどうかで判定し、
+// それ以外は CP932 として、CP932 に無い文字があれば Shift_JIS-2004 として読む。
+func decodeZIP(b []byte) (*zipContent, error) {
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	files := append([]*zip.File{}, r.File...)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	z := &zipContent{}
+	texts := []string{}
+	for _, file := range files {
+		// macOS で作られた ZIP ファイルのメタデータは読み飛ばす
+		if file.FileInfo().IsDir() || strings.HasPrefix(file.Name, "__MACOSX/") {
+			continue
+		}
+		ext := strings.ToLower(path.Ext(file.Name))
+		if ext != ".txt" && !imageExts[ext] {
+			continue
+		}
+
+		b, err := readZIPFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if imageExts[ext] {
+			z.Images = append(z.Images, aozora.Image{Name: file.Name, Data: b})
+			continue
+		}
+
+		text, encoding, err := decodeBytes(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+		texts = append(texts, text)
+		z.Files = append(z.Files, file.Name)
+		z.Encodings = append(z.Encodings, encoding)
+	}
+	if len(texts) == 0 {
+		return nil, errors.New("contents not found")
+	}
+
+	if len(texts) == 1 {
+		z.Text = texts[0]
+		return z, nil
+	}
+	for i := range texts {
+		texts[i] = strings.TrimRight(texts[i], "\r\n")
+	}
+	z.Text = strings.Join(texts, "\r\n\r\n") + "\r\n"
+	return z, nil
+}
+
+func readZIPFile(file *zip.File) ([]byte, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// errInvalidShiftJIS は CP932 としても Shift_JIS-2004 としても読めないテキストファイルのエラー
+var errInvalidShiftJIS = errors.New("invalid Shift_JIS text")
+
+// decodeBytes はテキストファイルの文字コードを判定して文字列にする
+//
+// CP932 のデコーダは読めないバイト列を U+FFFD にするので、U+FFFD が現れた場合は
+// JIS X 0213 の第3、第4水準の文字を含む Shift_JIS-2004 として読み直す。
+func decodeBytes(b []byte) (string, string, error) {
+	if bytes.HasPrefix(b, utf8BOM) {
+		return string(b[len(utf8BOM):]), encodingUTF8BOM, nil
+	}
+	if utf8.Valid(b) {
+		return string(b), encodingUTF8, nil
+	}
+
+	d, err := japanese.ShiftJIS.NewDecoder().Bytes(b)
+	if err != nil {
+		return "", "", err
+	}
+	if !bytes.ContainsRune(d, utf8.RuneError) {
+		return string(d), encodingShiftJIS, nil
+	}
+	text, ok := decodeShiftJIS2004(b)
+	if !ok {
+		return "", "", errInvalidShiftJIS
+	}
+	return text, encodingShiftJIS2004, nil
+}
+
+// sjis2004Plane2Rows は Shift_JIS-2004 の F0 から F4 の先行バイトが表す第2面の区の組
+var sjis2004Plane2Rows = [][2]int{{1, 8}, {3, 4}, {5, 12}, {13, 14}, {15, 78}}
+
+// decodeShiftJIS2004 は Shift_JIS-2004 のバイト列を文字列にする
+//
+// 2 バイトの文字は JIS X 0213 の面区点位置に直して対応表で引く。
+// 読めないバイト列や対応表に無い文字がある場合は false を返す。
+func decodeShiftJIS2004(b []byte) (string, bool) {
+	var sb strings.Builder
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c < 0x80:
+			sb.WriteByte(c)
+			continue
+		case c >= 0xA1 && c <= 0xDF:
+			// 半角カタカナ
+			sb.WriteRune(rune(c-0xA1) + 0xFF61)
+			continue
+		case !(c >= 0x81 && c <= 0x9F || c >= 0xE0 && c <= 0xFC):
+			return "", false
+		}
+		if i+1 >= len(b) {
+			return "", false
+		}
+		t := b[i+1]
+		if t < 0x40 || t == 0x7F || t > 0xFC {
+			return "", false
+		}
+		i++
+
+		// 先行バイトで奇数と偶数の区の組が決まり、後続バイトでどちらの区かと点が決まる
+		plane := 1
+		var rows [2]int
+		switch {
+		case c <= 0x9F:
+			first := int(c-0x81)*2 + 1
+			rows = [2]int{first, first + 1}
+		case c <= 0xEF:
+			first := int(c-0xC1)*2 + 1
+			rows = [2]int{first, first + 1}
+		case c <= 0xF4:
+			plane = 2
+			rows = sjis2004Plane2Rows[c-0xF0]
+		default:
+			plane = 2
+			first := int(c-0xF5)*2 + 79
+			rows = [2]int{first, first + 1}
+		}
+		row, cell := rows[0], int(t)-0x3F
+		if t >= 0x80 {
+			cell--
+		}
+		if t >= 0x9F {
+			row, cell = rows[1], int(t)-0x9E
+		}
+
+		char, ok := aozora.JISX0213(plane, row, cell)
+		if !ok {
+			return "", false
+		}
+		sb.WriteString(char)
+	}
+	return sb.String(), true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeZIP(t *testing.T) {
+	tests := []struct {
+		file      string
+		text      string
+		files     []string
+		encodings []string
+		images    []string
+	}{
+		{
+			file:      "testdata/example.zip",
+			text:      "テストデータ\n",
+			files:     []string{"example.txt"},
+			encodings: []string{encodingShiftJIS},
+		},
+		{
+			// CP932 にしか無い文字 (NEC 特殊文字、IBM 拡張文字) を含む
+			file:      "testdata/cp932.zip",
+			text:      "テスト\r\nテスト 太郎\r\n\r\n本文の①と髙\r\n",
+			files:     []string{"cp932.txt"},
+			encodings: []string{encodingShiftJIS},
+		},
+		{
+			// CP932 に無い JIS X 0213 の第3、第4水準の文字 (F040, 859F) を含む
+			file:      "testdata/sjis2004.zip",
+			text:      "テスト\r\n本文の𠂉とĄ\r\n",
+			files:     []string{"sjis2004.txt"},
+			encodings: []string{encodingShiftJIS2004},
+		},
+		{
+			file:      "testdata/utf8.zip",
+			text:      "テストデータ𠮷\n",
+			files:     []string{"utf8.txt"},
+			encodings: []string{encodingUTF8},
+		},
+		{
+			file:      "testdata/utf8bom.zip",
+			text:      "テストデータ\n",
+			files:     []string{"utf8bom.txt"},
+			encodings: []string{encodingUTF8BOM},
+		},
+		{
+			// テキストファイルは名前順に連結し、挿絵は別に取り出す
+			file:      "testdata/multi.zip",
+			text:      "一\r\n\r\n二\r\n",
+			files:     []string{"files/part1.txt", "files/part2.txt"},
+			encodings: []string{encodingShiftJIS, encodingShiftJIS},
+			images:    []string{"files/fig01.png"},
+		},
+	}
+	for _, tt := range tests {
+		b, err := os.ReadFile(tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeZIP(b)
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+		if got.Text != tt.text {
+			t.Errorf("%s: want %q, but got %q", tt.file, tt.text, got.Text)
+		}
+		if !reflect.DeepEqual(tt.files, got.Files) {
+			t.Errorf("%s: want %v, but got %v", tt.file, tt.files, got.Files)
+		}
+		if !reflect.DeepEqual(tt.encodings, got.Encodings) {
+			t.Errorf("%s: want %v, but got %v", tt.file, tt.encodings, got.Encodings)
+		}
+		images := []string{}
+		for _, image := range got.Images {
+			images = append(images, image.Name)
+			if len(image.Data) == 0 {
+				t.Errorf("%s: %s is empty", tt.file, image.Name)
+			}
+		}
+		if len(tt.images) == 0 {
+			tt.images = []string{}
+		}
+		if !reflect.DeepEqual(tt.images, images) {
+			t.Errorf("%s: want %v, but got %v", tt.file, tt.images, images)
+		}
+	}
+}
+
+func TestDecodeZIPNoText(t *testing.T) {
+	b, err := os.ReadFile("testdata/notext.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = decodeZIP(b)
+	if err == nil {
+		t.Error("want error for ZIP without text files")
+	}
+}
+
+func TestDecodeBytesInvalidShiftJIS(t *testing.T) {
+	// 後続バイトの無い先行バイトは Shift_JIS-2004 としても読めない
+	_, _, err := decodeBytes([]byte("\x83e\x83X\x83g\x82"))
+	if !errors.Is(err, errInvalidShiftJIS) {
+		t.Errorf("want %v, but got %v", errInvalidShiftJIS, err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/yuichi04/aozora-search/aozora"
+	"golang.org/x/text/width"
+)
+
+// showKWIC は word が現れる箇所を前後の文脈と一緒に output の形式で書き出す
+//
+// text の形式では語の位置が揃うように、前の文脈の左を空白で埋める。
+func showKWIC(w io.Writer, store *aozora.SQLiteStore, word string, opts aozora.KWICOptions, output string) error {
+	lines, err := store.KWIC(word, opts)
+	if err != nil {
+		return err
+	}
+	records := []kwicRecord{}
+	leftWidth := 0
+	for _, l := range lines {
+		records = append(records, kwicRecord{
+			AuthorID: l.AuthorID,
+			Author:   l.Author,
+			TitleID:  l.TitleID,
+			Title:    l.Title,
+			Left:     l.Left,
+			Keyword:  l.Keyword,
+			Right:    l.Right,
+			BaseForm: l.BaseForm,
+			POS:      l.POS,
+		})
+		leftWidth = max(leftWidth, displayWidth(l.Left))
+	}
+	return writeRecords(w, output, records, func(w io.Writer, r kwicRecord) {
+		left := strings.Repeat(" ", leftWidth-displayWidth(r.Left)) + r.Left
+		fmt.Fprintf(w, "%s 【%s】 %s\t%s % 5s: %s (%s)\n", left, r.Keyword, r.Right, r.AuthorID, r.TitleID, r.Title, r.Author)
+	})
+}
+
+// displayWidth は端末に表示したときの s の幅を返す。全角の文字は 2 とする
+func displayWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		switch width.LookupRune(r).Kind() {
+		case width.EastAsianWide, width.EastAsianFullwidth:
+			n += 2
+		default:
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yuichi04/aozora-search/aozora"
+)
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{s: "", want: 0},
+		{s: "abc", want: 3},
+		{s: "吾輩", want: 4},
+		{s: "ｱｲ", want: 2},
+		{s: "Ａ猫 a", want: 6},
+	}
+	for _, tt := range tests {
+		got := displayWidth(tt.s)
+		if got != tt.want {
+			t.Errorf("%q: want %v, but got %v", tt.s, tt.want, got)
+		}
+	}
+}
+
+func TestShowKWICAlign(t *testing.T) {
+	store := openTestStore(t)
+
+	// 一人 の前の文脈は本文の先頭で切れるので短くなる
+	var buf bytes.Buffer
+	err := showKWIC(&buf, store, "の", aozora.KWICOptions{Width: 4}, outputText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := " ある日 【の】 事でござ\t000879    92: 蜘蛛の糸 (芥川 竜之介)\n" +
+		"   一人 【の】 下人が、\t000879   128: 羅生門 (芥川 竜之介)\n" +
+		"羅生門\" 【の】 下で 雨\t000879   128: 羅生門 (芥川 竜之介)\n"
+	if buf.String() != want {
+		t.Errorf("want\n%s\nbut got\n%s", want, buf.String())
+	}
+}
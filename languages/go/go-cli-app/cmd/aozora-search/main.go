@@ -1,52 +1,453 @@
 package main
 
 import (
-	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yuichi04/aozora-search/aozora"
 )
 
+const usage = `
+Usage of ./aozora-search [sub-command] [...]:
+  -d string
+        database (default "database.sqlite")
+  -output string
+        output format of authors, titles, content, query and kwic (default "text")
+        text:   human readable lines
+        json:   an array of records
+        ndjson: one record per line
+        csv:    a header line and one record per line
+        tsv:    same as csv, but separated by tabs
+
+Sub-commands:
+    authors [-sort id|yomi|birth]
+    titles  [Author]
+    works   [-author Author] [-char-type 新字新仮名] [-translated] [-from YEAR] [-to YEAR]
+            [-copyright yes|no] [-sort id|title|year|release]
+    info    [Author] [Title]
+    content [Author] [Title] [-format plain|ruby-paren|html]
+    gaiji   [Author] [Title]
+    query   [Query] [-limit N] [-offset N]
+    kwic    [Word] [-width N] [-lemma] [-pos 動詞] [-author Author]
+    reindex
+    migrate-fts5
+    shell   [-history FILE]
+
+Author and Title are IDs or parts of names, ignoring the differences of
+hiragana/katakana, full-width/half-width and old/new kanji forms.
+Author can be omitted from info, content and gaiji.
+
+query ranks hits by bm25 and accepts NEAR(a b, N) only after migrate-fts5,
+which requires building with -tags sqlite_fts5. Until then, hits are ordered by
+author and title IDs. After migrate-fts5, programs built without the tag can no
+longer open the database.
+
+Records:
+    authors: id, name, yomi, romaji, birth_date, death_date
+    titles:  author_id, title_id, title
+    content: author_id, title_id, format, content
+    query:   author_id, author, title_id, title, snippet
+    kwic:    author_id, author, title_id, title, left, keyword, right, base_form, pos
+`
+
+// showAuthors は作者の一覧を sortBy の順に output の形式で書き出す
+func showAuthors(w io.Writer, store aozora.Store, sortBy string, output string) error {
+	authors, err := store.Authors()
+	if err != nil {
+		return err
+	}
+	switch sortBy {
+	case "id":
+	case "yomi":
+		sort.SliceStable(authors, func(i, j int) bool {
+			return aozora.SortKey(authors[i].Yomi) < aozora.SortKey(authors[j].Yomi)
+		})
+	case "birth":
+		// 生年月日の分からない作者は最後にする
+		sort.SliceStable(authors, func(i, j int) bool {
+			a, b := authors[i].BirthDate, authors[j].BirthDate
+			return a != "" && (b == "" || a < b)
+		})
+	default:
+		return fmt.Errorf("unknown sort order: %q", sortBy)
+	}
+	records := []authorRecord{}
+	for _, a := range authors {
+		records = append(records, authorRecord{
+			ID:        a.ID,
+			Name:      a.Name,
+			Yomi:      a.Yomi,
+			Romaji:    a.Romaji,
+			BirthDate: a.BirthDate,
+			DeathDate: a.DeathDate,
+		})
+	}
+	return writeRecords(w, output, records, func(w io.Writer, r authorRecord) {
+		fmt.Fprintf(w, "%s %s\n", r.ID, r.Name)
+	})
+}
+
+// showTitles は作者の作品の一覧を output の形式で書き出す
+func showTitles(w io.Writer, store aozora.Store, authorID string, output string) error {
+	titles, err := store.Titles(authorID)
+	if err != nil {
+		return err
+	}
+	records := []titleRecord{}
+	for _, t := range titles {
+		records = append(records, titleRecord{AuthorID: t.AuthorID, TitleID: t.ID, Title: t.Title})
+	}
+	return writeRecords(w, output, records, func(w io.Writer, r titleRecord) {
+		fmt.Fprintf(w, "%s %s\n", r.TitleID, r.Title)
+	})
+}
+
+// showWorks は条件に合う作品の一覧を表示する
+func showWorks(store *aozora.SQLiteStore, filter aozora.WorkFilter) error {
+	works, err := store.Works(filter)
+	if err != nil {
+		return err
+	}
+	for _, work := range works {
+		year := "----"
+		if y := aozora.FirstYear(work.FirstAppearance); y > 0 {
+			year = strconv.Itoa(y)
+		}
+		line := fmt.Sprintf("%s % 5s: %s (%s) %s %s", work.AuthorID, work.TitleID, work.Title, work.Author, year, work.CharType)
+		if work.Translator != "" {
+			line += " 訳:" + work.Translator
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+	return nil
+}
+
+// showInfo は作品の詳細な情報を表示する
+func showInfo(store *aozora.SQLiteStore, authorID string, titleID string) error {
+	work, err := store.Work(authorID, titleID)
+	if err != nil {
+		if errors.Is(err, aozora.ErrNotFound) {
+			return fmt.Errorf("content not found: %s %s", authorID, titleID)
+		}
+		return err
+	}
+	copyright := "なし"
+	if work.Copyright {
+		copyright = "あり"
+	}
+	fields := [][2]string{
+		{"作品名", work.Title},
+		{"作品名読み", work.TitleYomi},
+		{"副題", work.Subtitle},
+		{"原題", work.OriginalTitle},
+		{"作家名", work.Author},
+		{"作家名読み", work.AuthorYomi},
+		{"ローマ字表記", work.AuthorRomaji},
+		{"生年", work.BirthDate},
+		{"没年", work.DeathDate},
+		{"翻訳者", work.Translator},
+		{"初出", work.FirstAppearance},
+		{"文字遣い種別", work.CharType},
+		{"著作権", copyright},
+		{"公開日", work.ReleaseDate},
+		{"図書カード", work.SiteURL},
+		{"ファイル", work.ZipURL},
+	}
+	for _, field := range fields {
+		if field[1] != "" {
+			fmt.Printf("%s: %s\n", field[0], field[1])
+		}
+	}
+	return nil
+}
+
+// showContent は format の形式にした作品の本文を output の形式で書き出す
+func showContent(w io.Writer, store aozora.Store, authorID string, titleID string, format string, output string) error {
+	content, err := store.Content(authorID, titleID)
+	if err != nil {
+		if errors.Is(err, aozora.ErrNotFound) {
+			return fmt.Errorf("content not found: %s %s", authorID, titleID)
+		}
+		return err
+	}
+	text, err := aozora.ParseText(content).Render(format)
+	if err != nil {
+		return err
+	}
+	records := []contentRecord{{AuthorID: authorID, TitleID: titleID, Format: format, Content: text}}
+	return writeRecords(w, output, records, func(w io.Writer, r contentRecord) {
+		fmt.Fprintln(w, r.Content)
+	})
+}
+
+// showGaiji は作品の外字の注記と置き換えた文字を表示する
+//
+// 置き換えられなかった外字は GaijiPlaceholder で表示する。
+func showGaiji(store aozora.Store, authorID string, titleID string) error {
+	content, err := store.Content(authorID, titleID)
+	if err != nil {
+		if errors.Is(err, aozora.ErrNotFound) {
+			return fmt.Errorf("content not found: %s %s", authorID, titleID)
+		}
+		return err
+	}
+	resolved := 0
+	text := aozora.ParseText(content)
+	for _, g := range text.Gaiji {
+		char := g.Char
+		if g.Resolved() {
+			resolved++
+		} else {
+			char = aozora.GaijiPlaceholder
+		}
+		fmt.Printf("%s\t%s\t%s\n", char, g.Ref, g.Note)
+	}
+	fmt.Printf("%d/%d resolved\n", resolved, len(text.Gaiji))
+	return nil
+}
+
+// queryContent は全文検索にマッチした作品の一覧を、マッチした語の周りの抜粋と一緒に
+// output の形式で書き出す
+func queryContent(w io.Writer, store *aozora.SQLiteStore, query string, opts aozora.SearchOptions, output string) error {
+	hits, err := store.SearchWith(query, opts)
+	if err != nil {
+		return err
+	}
+	records := []hitRecord{}
+	for _, hit := range hits {
+		records = append(records, hitRecord{
+			AuthorID: hit.AuthorID,
+			Author:   hit.Author,
+			TitleID:  hit.TitleID,
+			Title:    hit.Title,
+			Snippet:  hit.Snippet,
+		})
+	}
+	return writeRecords(w, output, records, func(w io.Writer, r hitRecord) {
+		fmt.Fprintf(w, "%s % 5s: %s (%s)\n", r.AuthorID, r.TitleID, r.Title, r.Author)
+		fmt.Fprintf(w, "    %s\n", r.Snippet)
+	})
+}
+
+// resolveWork は [作者 作品] か [作品] の引数から作品を一つに決める
+//
+// 作者と作品は ID か名前の一部で指定できる。
+func resolveWork(store *aozora.SQLiteStore, args []string) (*aozora.Entry, error) {
+	if len(args) == 1 {
+		return store.ResolveWork("", args[0])
+	}
+	return store.ResolveWork(args[0], args[1])
+}
+
+// describeError は err を表示する文字列にする。名前が曖昧な場合は候補を一行ずつ並べる
+func describeError(err error) string {
+	var ae *aozora.AmbiguousError
+	if !errors.As(err, &ae) {
+		return err.Error()
+	}
+	return err.Error() + "\n  " + strings.Join(ae.Candidates, "\n  ")
+}
+
+// parseArgs はフラグと引数が混ざっていても解析し、フラグ以外の引数を返す
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	rest := []string{}
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return rest
+		}
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
+}
+
 func main() {
-	var dsn string
-	dsn = *flag.String("d", "database.sqlite", "database description")
+	// flag.Parse の後で参照しないと -d の値が反映されない
+	dsn := flag.String("d", "database.sqlite", "database")
+	output := flag.String("output", outputText, "output format (text, json, ndjson, csv, tsv)")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
 	flag.Parse()
 
 	if flag.NArg() == 0 {
 		flag.Usage()
 		os.Exit(2)
 	}
+	if err := checkOutput(*output); err != nil {
+		log.Fatal(err)
+	}
+	switch flag.Arg(0) {
+	case "authors", "titles", "content", "query", "kwic":
+	default:
+		if *output != outputText {
+			log.Fatalf("%s does not support -output %s", flag.Arg(0), *output)
+		}
+	}
 
-	db, err := sql.Open("sqlite3", dsn)
+	store, err := aozora.Open(*dsn)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
+	defer store.Close()
 
 	switch flag.Arg(0) {
 	case "authors":
-		err = showAuthors(db)
+		fs := flag.NewFlagSet("authors", flag.ExitOnError)
+		fs.Usage = flag.Usage
+		sortBy := fs.String("sort", "id", "sort order (id, yomi, birth)")
+		if len(parseArgs(fs, flag.Args()[1:])) != 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = showAuthors(os.Stdout, store, *sortBy, *output)
+	case "works":
+		fs := flag.NewFlagSet("works", flag.ExitOnError)
+		fs.Usage = flag.Usage
+		var filter aozora.WorkFilter
+		fs.StringVar(&filter.AuthorID, "author", "", "author ID or name")
+		fs.StringVar(&filter.CharType, "char-type", "", "character type (e.g. 新字新仮名)")
+		fs.BoolVar(&filter.Translated, "translated", false, "only translated works")
+		fs.IntVar(&filter.FromYear, "from", 0, "first published in or after the year")
+		fs.IntVar(&filter.ToYear, "to", 0, "first published in or before the year")
+		copyright := fs.String("copyright", "", "copyright status (yes, no)")
+		fs.StringVar(&filter.Sort, "sort", aozora.SortByID, "sort order (id, title, year, release)")
+		if len(parseArgs(fs, flag.Args()[1:])) != 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		switch *copyright {
+		case "":
+		case "yes", "no":
+			b := *copyright == "yes"
+			filter.Copyright = &b
+		default:
+			log.Fatalf("invalid copyright status: %q", *copyright)
+		}
+		if filter.AuthorID != "" {
+			var author *aozora.Author
+			author, err = store.ResolveAuthor(filter.AuthorID)
+			if err != nil {
+				break
+			}
+			filter.AuthorID = author.ID
+		}
+		err = showWorks(store, filter)
+	case "info":
+		if flag.NArg() != 2 && flag.NArg() != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		var work *aozora.Entry
+		work, err = resolveWork(store, flag.Args()[1:])
+		if err == nil {
+			err = showInfo(store, work.AuthorID, work.TitleID)
+		}
 	case "titles":
 		if flag.NArg() != 2 {
 			flag.Usage()
 			os.Exit(2)
 		}
-		err = showTitles(db, flag.Arg(1))
+		var author *aozora.Author
+		author, err = store.ResolveAuthor(flag.Arg(1))
+		if err == nil {
+			err = showTitles(os.Stdout, store, author.ID, *output)
+		}
 	case "content":
-		if flag.NArg() != 3 {
+		fs := flag.NewFlagSet("content", flag.ExitOnError)
+		fs.Usage = flag.Usage
+		format := fs.String("format", aozora.FormatPlain, "output format (plain, ruby-paren, html)")
+		args := parseArgs(fs, flag.Args()[1:])
+		if len(args) != 1 && len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		var work *aozora.Entry
+		work, err = resolveWork(store, args)
+		if err == nil {
+			err = showContent(os.Stdout, store, work.AuthorID, work.TitleID, *format, *output)
+		}
+	case "gaiji":
+		if flag.NArg() != 2 && flag.NArg() != 3 {
 			flag.Usage()
 			os.Exit(2)
 		}
-		err = showContent(db, flag.Arg(1), flag.Arg(2))
+		var work *aozora.Entry
+		work, err = resolveWork(store, flag.Args()[1:])
+		if err == nil {
+			err = showGaiji(store, work.AuthorID, work.TitleID)
+		}
 	case "query":
-		if flag.NArg() != 2 {
+		fs := flag.NewFlagSet("query", flag.ExitOnError)
+		fs.Usage = flag.Usage
+		var opts aozora.SearchOptions
+		fs.IntVar(&opts.Limit, "limit", 20, "maximum number of results (0 for all)")
+		fs.IntVar(&opts.Offset, "offset", 0, "number of results to skip")
+		args := parseArgs(fs, flag.Args()[1:])
+		if len(args) != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = queryContent(os.Stdout, store, args[0], opts, *output)
+	case "kwic":
+		fs := flag.NewFlagSet("kwic", flag.ExitOnError)
+		fs.Usage = flag.Usage
+		var opts aozora.KWICOptions
+		fs.IntVar(&opts.Width, "width", 20, "number of characters of the context on each side")
+		fs.BoolVar(&opts.Lemma, "lemma", false, "match the base form instead of the surface form")
+		fs.StringVar(&opts.POS, "pos", "", "part of speech (e.g. 動詞, 名詞-固有名詞)")
+		author := fs.String("author", "", "author ID or name")
+		args := parseArgs(fs, flag.Args()[1:])
+		if len(args) != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if *author != "" {
+			var a *aozora.Author
+			a, err = store.ResolveAuthor(*author)
+			if err != nil {
+				break
+			}
+			opts.AuthorID = a.ID
+		}
+		err = showKWIC(os.Stdout, store, args[0], opts, *output)
+	case "shell":
+		fs := flag.NewFlagSet("shell", flag.ExitOnError)
+		fs.Usage = flag.Usage
+		history := fs.String("history", "", "file to load and save the command history")
+		if len(parseArgs(fs, flag.Args()[1:])) != 0 {
 			flag.Usage()
 			os.Exit(2)
 		}
-		err = queryContent(db, flag.Arg(1))
+		sh := newShell(store, os.Stdin, os.Stdout)
+		if *history != "" {
+			f, herr := sh.loadHistory(*history)
+			if herr != nil {
+				log.Fatal(herr)
+			}
+			defer f.Close()
+		}
+		err = sh.run()
+	case "reindex":
+		// 既存のデータベースの全文検索のインデックスを作り直す
+		err = store.Reindex()
+	case "migrate-fts5":
+		// 全文検索のインデックスを FTS5 に移す。FTS4 には戻せない
+		err = store.MigrateFTS5()
+	default:
+		flag.Usage()
+		os.Exit(2)
 	}
 
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal(describeError(err))
 	}
 }
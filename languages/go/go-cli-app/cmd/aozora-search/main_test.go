@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yuichi04/aozora-search/aozora"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// openTestStore はテスト用の作品を登録したデータベースを開く
+func openTestStore(t *testing.T) *aozora.SQLiteStore {
+	t.Helper()
+
+	store, err := aozora.Open(filepath.Join(t.TempDir(), "database.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+	})
+
+	fixtures := []struct {
+		entry   aozora.Entry
+		content string
+	}{
+		{
+			entry: aozora.Entry{
+				AuthorID: "000879", Author: "芥川 竜之介", AuthorYomi: "あくたがわ りゅうのすけ", AuthorRomaji: "Akutagawa, Ryunosuke",
+				BirthDate: "1892-03-01", DeathDate: "1927-07-24",
+				TitleID: "128", Title: "羅生門",
+			},
+			content: "一人の下人《げにん》が、\"羅生門\"の下で\n雨やみを待っていた。",
+		},
+		{
+			entry:   aozora.Entry{AuthorID: "000879", Author: "芥川 竜之介", TitleID: "92", Title: "蜘蛛の糸"},
+			content: "ある日の事でございます。誰もいない。",
+		},
+		{
+			entry:   aozora.Entry{AuthorID: "000148", Author: "夏目 漱石", TitleID: "789", Title: "吾輩は猫である"},
+			content: "吾輩は猫である。名前はまだ無い。",
+		},
+	}
+	for _, f := range fixtures {
+		err = store.AddEntry(&f.entry, f.content)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+// checkGolden は got を testdata の name のファイルと比べる。-update の場合はファイルを書き換える
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := os.WriteFile(path, got, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Errorf("%s: want\n%s\nbut got\n%s", name, want, got)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	store := openTestStore(t)
+
+	commands := []struct {
+		name string
+		run  func(w io.Writer, output string) error
+	}{
+		{
+			name: "authors",
+			run: func(w io.Writer, output string) error {
+				return showAuthors(w, store, "id", output)
+			},
+		},
+		{
+			name: "titles",
+			run: func(w io.Writer, output string) error {
+				return showTitles(w, store, "000879", output)
+			},
+		},
+		{
+			name: "content",
+			run: func(w io.Writer, output string) error {
+				return showContent(w, store, "000879", "128", aozora.FormatRubyParen, output)
+			},
+		},
+		{
+			name: "query",
+			run: func(w io.Writer, output string) error {
+				return queryContent(w, store, "下人", aozora.SearchOptions{}, output)
+			},
+		},
+		{
+			name: "kwic",
+			run: func(w io.Writer, output string) error {
+				return showKWIC(w, store, "いる", aozora.KWICOptions{Width: 6, Lemma: true}, output)
+			},
+		},
+	}
+	for _, c := range commands {
+		for _, output := range outputFormats {
+			var buf bytes.Buffer
+			err := c.run(&buf, output)
+			if err != nil {
+				t.Fatalf("%s %s: %v", c.name, output, err)
+			}
+			checkGolden(t, c.name+"."+output, buf.Bytes())
+		}
+	}
+}
+
+func TestOutputEmpty(t *testing.T) {
+	store := openTestStore(t)
+
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{output: outputText, want: ""},
+		{output: outputJSON, want: "[]\n"},
+		{output: outputNDJSON, want: ""},
+		{output: outputCSV, want: "author_id,title_id,title\n"},
+		{output: outputTSV, want: "author_id\ttitle_id\ttitle\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := showTitles(&buf, store, "999999", tt.output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s: want %q, but got %q", tt.output, tt.want, buf.String())
+		}
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	for _, output := range outputFormats {
+		if err := checkOutput(output); err != nil {
+			t.Errorf("%s: want nil, but got %v", output, err)
+		}
+	}
+	if err := checkOutput("xml"); err == nil {
+		t.Error("want error for unknown output format")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 出力の形式
+const (
+	outputText   = "text"   // 人が読むための形式
+	outputJSON   = "json"   // レコードの配列
+	outputNDJSON = "ndjson" // 一行に一つのレコード
+	outputCSV    = "csv"    // 一行目が列名の CSV
+	outputTSV    = "tsv"    // 一行目が列名のタブ区切り。値の引用は CSV と同じ
+)
+
+// outputFormats は -output に指定できる形式の一覧
+var outputFormats = []string{outputText, outputJSON, outputNDJSON, outputCSV, outputTSV}
+
+// checkOutput は -output に指定された形式が使えるか調べる
+func checkOutput(format string) error {
+	for _, f := range outputFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format: %q (%s)", format, strings.Join(outputFormats, ", "))
+}
+
+// record は構造化した形式で書き出すレコード
+//
+// JSON と NDJSON では構造体の json タグを、CSV と TSV では columns と values を使う。
+type record interface {
+	// columns は CSV と TSV の列名
+	columns() []string
+	// values は columns と同じ順の値
+	values() []string
+}
+
+// writeRecords は records を format の形式で w に書き出す
+//
+// text の形式では records を一件ずつ text で書き出す。
+func writeRecords[R record](w io.Writer, format string, records []R, text func(w io.Writer, r R)) error {
+	switch format {
+	case outputText:
+		for _, r := range records {
+			text(w, r)
+		}
+		return nil
+	case outputJSON:
+		if records == nil {
+			records = []R{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case outputNDJSON:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for _, r := range records {
+			err := enc.Encode(r)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case outputCSV, outputTSV:
+		cw := csv.NewWriter(w)
+		if format == outputTSV {
+			cw.Comma = '\t'
+		}
+		var zero R
+		err := cw.Write(zero.columns())
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			err = cw.Write(r.values())
+			if err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return checkOutput(format)
+	}
+}
+
+// authorRecord は authors の一件分
+type authorRecord struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Yomi      string `json:"yomi"`
+	Romaji    string `json:"romaji"`
+	BirthDate string `json:"birth_date"`
+	DeathDate string `json:"death_date"`
+}
+
+func (authorRecord) columns() []string {
+	return []string{"id", "name", "yomi", "romaji", "birth_date", "death_date"}
+}
+
+func (r authorRecord) values() []string {
+	return []string{r.ID, r.Name, r.Yomi, r.Romaji, r.BirthDate, r.DeathDate}
+}
+
+// titleRecord は titles の一件分
+type titleRecord struct {
+	AuthorID string `json:"author_id"`
+	TitleID  string `json:"title_id"`
+	Title    string `json:"title"`
+}
+
+func (titleRecord) columns() []string {
+	return []string{"author_id", "title_id", "title"}
+}
+
+func (r titleRecord) values() []string {
+	return []string{r.AuthorID, r.TitleID, r.Title}
+}
+
+// contentRecord は content の結果。本文は format の形式にする
+type contentRecord struct {
+	AuthorID string `json:"author_id"`
+	TitleID  string `json:"title_id"`
+	Format   string `json:"format"`
+	Content  string `json:"content"`
+}
+
+func (contentRecord) columns() []string {
+	return []string{"author_id", "title_id", "format", "content"}
+}
+
+func (r contentRecord) values() []string {
+	return []string{r.AuthorID, r.TitleID, r.Format, r.Content}
+}
+
+// hitRecord は query の一件分。snippet ではマッチした語を【】で囲む
+type hitRecord struct {
+	AuthorID string `json:"author_id"`
+	Author   string `json:"author"`
+	TitleID  string `json:"title_id"`
+	Title    string `json:"title"`
+	Snippet  string `json:"snippet"`
+}
+
+func (hitRecord) columns() []string {
+	return []string{"author_id", "author", "title_id", "title", "snippet"}
+}
+
+func (r hitRecord) values() []string {
+	return []string{r.AuthorID, r.Author, r.TitleID, r.Title, r.Snippet}
+}
+
+// kwicRecord は kwic の一件分
+type kwicRecord struct {
+	AuthorID string `json:"author_id"`
+	Author   string `json:"author"`
+	TitleID  string `json:"title_id"`
+	Title    string `json:"title"`
+	Left     string `json:"left"`
+	Keyword  string `json:"keyword"`
+	Right    string `json:"right"`
+	BaseForm string `json:"base_form"`
+	POS      string `json:"pos"`
+}
+
+func (kwicRecord) columns() []string {
+	return []string{"author_id", "author", "title_id", "title", "left", "keyword", "right", "base_form", "pos"}
+}
+
+func (r kwicRecord) values() []string {
+	return []string{r.AuthorID, r.Author, r.TitleID, r.Title, r.Left, r.Keyword, r.Right, r.BaseForm, r.POS}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yuichi04/aozora-search/aozora"
+)
+
+const shellPrompt = "aozora> "
+
+const shellHelp = `Commands:
+    authors                      list authors
+    titles  Author               list titles of the author
+    content [Author] Title [plain|ruby-paren|html]
+                                 show the content page by page
+    query   [Query]              search, or run the last query again
+    refine  Query                narrow the last query down with AND
+    exclude Query                remove hits of the query from the last query
+    next, prev                   show the next or previous page of the last query
+    page    N                    set the number of lines or hits per page
+    complete Prefix              list author names and titles starting with the prefix
+    history                      list the command history
+    !!, !N                       run the last or the N-th command again
+    help                         show this help
+    exit, quit                   leave the shell
+
+Author and Title are IDs or parts of names.
+End a line with a tab to list completions of its last word.
+`
+
+// shell は一行ずつ読んだコマンドを実行する対話モード
+//
+// 端末を raw モードにしないので、入力を標準入力から流し込んでテストできる。
+type shell struct {
+	store   *aozora.SQLiteStore
+	in      *bufio.Scanner
+	out     io.Writer
+	history []string
+	histW   io.Writer // 履歴を追記するファイル。nil の場合は保存しない
+
+	page   int    // 一画面に表示する行数と検索結果の数
+	query  string // 最後に実行した検索式
+	offset int    // 最後に表示した検索結果の位置
+
+	names []string // 補完の候補。最初に補完するときに読み込む
+}
+
+// newShell は in から読んだコマンドを実行し、結果を out に書き出す shell を作る
+func newShell(store *aozora.SQLiteStore, in io.Reader, out io.Writer) *shell {
+	return &shell{
+		store: store,
+		in:    bufio.NewScanner(in),
+		out:   out,
+		page:  20,
+	}
+}
+
+// loadHistory は path のファイルから履歴を読み込み、以降のコマンドを追記するように開く
+func (sh *shell) loadHistory(path string) (io.Closer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line != "" {
+			sh.history = append(sh.history, line)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	sh.histW = f
+	return f, nil
+}
+
+// readLine はプロンプトを表示して一行読む。入力が終わった場合は false を返す
+func (sh *shell) readLine(prompt string) (string, bool) {
+	fmt.Fprint(sh.out, prompt)
+	if !sh.in.Scan() {
+		fmt.Fprintln(sh.out)
+		return "", false
+	}
+	return sh.in.Text(), true
+}
+
+// run は入力が終わるか exit が入力されるまでコマンドを実行する
+//
+// コマンドの失敗は表示して続ける。
+func (sh *shell) run() error {
+	for {
+		line, ok := sh.readLine(shellPrompt)
+		if !ok {
+			return sh.in.Err()
+		}
+		if strings.HasSuffix(line, "\t") {
+			sh.showCompletions(lastWord(strings.TrimRight(line, "\t")))
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		line, err := sh.expandHistory(line)
+		if err != nil {
+			fmt.Fprintf(sh.out, "error: %v\n", err)
+			continue
+		}
+		sh.addHistory(line)
+
+		if line == "exit" || line == "quit" {
+			return nil
+		}
+		err = sh.exec(line)
+		if err != nil {
+			fmt.Fprintf(sh.out, "error: %s\n", describeError(err))
+		}
+	}
+}
+
+// expandHistory は !! と !N を履歴のコマンドに置き換える
+func (sh *shell) expandHistory(line string) (string, error) {
+	if !strings.HasPrefix(line, "!") {
+		return line, nil
+	}
+	if len(sh.history) == 0 {
+		return "", errors.New("no history")
+	}
+	if line == "!!" {
+		line = sh.history[len(sh.history)-1]
+	} else {
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 1 || n > len(sh.history) {
+			return "", fmt.Errorf("no such history: %s", line)
+		}
+		line = sh.history[n-1]
+	}
+	fmt.Fprintln(sh.out, line)
+	return line, nil
+}
+
+func (sh *shell) addHistory(line string) {
+	sh.history = append(sh.history, line)
+	if sh.histW != nil {
+		fmt.Fprintln(sh.histW, line)
+	}
+}
+
+// exec は一行のコマンドを実行する
+func (sh *shell) exec(line string) error {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	args := strings.Fields(arg)
+
+	switch name {
+	case "help":
+		fmt.Fprint(sh.out, shellHelp)
+	case "authors":
+		return showAuthors(sh.out, sh.store, "id", outputText)
+	case "titles":
+		if arg == "" {
+			return errors.New("usage: titles Author")
+		}
+		author, err := sh.store.ResolveAuthor(arg)
+		if err != nil {
+			return err
+		}
+		return showTitles(sh.out, sh.store, author.ID, outputText)
+	case "content":
+		format := aozora.FormatPlain
+		if len(args) > 1 && slices.Contains(aozora.Formats, args[len(args)-1]) {
+			format = args[len(args)-1]
+			args = args[:len(args)-1]
+		}
+		if len(args) != 1 && len(args) != 2 {
+			return errors.New("usage: content [Author] Title [plain|ruby-paren|html]")
+		}
+		work, err := resolveWork(sh.store, args)
+		if err != nil {
+			return err
+		}
+		return sh.showContent(work.AuthorID, work.TitleID, format)
+	case "query":
+		if arg == "" {
+			if sh.query == "" {
+				return errors.New("no query to run again")
+			}
+			arg = sh.query
+		}
+		return sh.search(arg, 0)
+	case "refine", "exclude":
+		if arg == "" {
+			return fmt.Errorf("usage: %s Query", name)
+		}
+		if sh.query == "" {
+			return errors.New("no query to refine")
+		}
+		op := " AND "
+		if name == "exclude" {
+			op = " NOT "
+		}
+		return sh.search("("+sh.query+")"+op+arg, 0)
+	case "next", "prev":
+		if sh.query == "" {
+			return errors.New("no query")
+		}
+		offset := sh.offset + sh.page
+		if name == "prev" {
+			offset = max(sh.offset-sh.page, 0)
+		}
+		return sh.search(sh.query, offset)
+	case "page":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return errors.New("usage: page N (N > 0)")
+		}
+		sh.page = n
+	case "complete":
+		sh.showCompletions(arg)
+	case "history":
+		for i, h := range sh.history {
+			fmt.Fprintf(sh.out, "%4d  %s\n", i+1, h)
+		}
+	default:
+		return fmt.Errorf("unknown command: %s (type help for commands)", name)
+	}
+	return nil
+}
+
+// search は query で検索し、offset から一画面分の結果を表示する
+func (sh *shell) search(query string, offset int) error {
+	err := queryContent(sh.out, sh.store, query, aozora.SearchOptions{Limit: sh.page, Offset: offset}, outputText)
+	if err != nil {
+		return err
+	}
+	sh.query = query
+	sh.offset = offset
+	fmt.Fprintf(sh.out, "[%s] from %d\n", query, offset+1)
+	return nil
+}
+
+// showContent は作品の本文を一画面ずつ表示する
+//
+// 一画面ごとに入力を待ち、q が入力されるか入力が終わった場合は途中でやめる。
+func (sh *shell) showContent(authorID, titleID, format string) error {
+	var b strings.Builder
+	err := showContent(&b, sh.store, authorID, titleID, format, outputText)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	for start := 0; start < len(lines); start += sh.page {
+		end := min(start+sh.page, len(lines))
+		for _, line := range lines[start:end] {
+			fmt.Fprintln(sh.out, line)
+		}
+		if end == len(lines) {
+			break
+		}
+		answer, ok := sh.readLine(fmt.Sprintf("-- %d/%d lines (Enter: next page, q: quit) --", end, len(lines)))
+		if !ok || strings.TrimSpace(answer) == "q" {
+			break
+		}
+	}
+	return nil
+}
+
+// completions は prefix で始まる作者名と作品名を返す
+func (sh *shell) completions(prefix string) ([]string, error) {
+	if sh.names == nil {
+		authors, err := sh.store.Authors()
+		if err != nil {
+			return nil, err
+		}
+		works, err := sh.store.Works(aozora.WorkFilter{})
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		names := []string{}
+		for _, a := range authors {
+			if !seen[a.Name] {
+				seen[a.Name] = true
+				names = append(names, a.Name)
+			}
+		}
+		for _, w := range works {
+			if !seen[w.Title] {
+				seen[w.Title] = true
+				names = append(names, w.Title)
+			}
+		}
+		sort.Strings(names)
+		sh.names = names
+	}
+
+	candidates := []string{}
+	for _, name := range sh.names {
+		if strings.HasPrefix(name, prefix) {
+			candidates = append(candidates, name)
+		}
+	}
+	return candidates, nil
+}
+
+func (sh *shell) showCompletions(prefix string) {
+	candidates, err := sh.completions(prefix)
+	if err != nil {
+		fmt.Fprintf(sh.out, "error: %v\n", err)
+		return
+	}
+	for _, c := range candidates {
+		fmt.Fprintln(sh.out, c)
+	}
+}
+
+// lastWord は line の最後の空白より後の部分を返す
+func lastWord(line string) string {
+	return line[strings.LastIndexAny(line, " \t")+1:]
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/yuichi04/aozora-search/aozora"
+)
+
+// runShell は script を標準入力として shell を実行し、出力を返す
+//
+// history が空でなければ履歴をそのファイルから読み込み、追記する。
+func runShell(t *testing.T, store *aozora.SQLiteStore, script string, history string) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	sh := newShell(store, strings.NewReader(script), &out)
+	if history != "" {
+		f, err := sh.loadHistory(history)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+	}
+	err := sh.run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestShell(t *testing.T) {
+	store := openTestStore(t)
+
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{
+			name:   "titles",
+			script: "titles 000879\nexit\n",
+			want:   "aozora> 92 蜘蛛の糸\n128 羅生門\naozora> ",
+		},
+		{
+			name:   "unknown command",
+			script: "foo\n\ntitles\n",
+			want:   "aozora> error: unknown command: foo (type help for commands)\naozora> aozora> error: usage: titles Author\naozora> \n",
+		},
+		{
+			name:   "history",
+			script: "titles 000148\n!!\nhistory\n!1\n!9\n",
+			want: "aozora> 789 吾輩は猫である\n" +
+				"aozora> titles 000148\n789 吾輩は猫である\n" +
+				"aozora>    1  titles 000148\n   2  titles 000148\n   3  history\n" +
+				"aozora> titles 000148\n789 吾輩は猫である\n" +
+				"aozora> error: no such history: !9\n" +
+				"aozora> \n",
+		},
+		{
+			name:   "names",
+			script: "titles 芥川龍之介\ncontent ｱｸﾀｶﾞﾜ 羅生 ruby-paren\ncontent 猫\ncontent 犬\n",
+			want: "aozora> 92 蜘蛛の糸\n128 羅生門\n" +
+				"aozora> 一人の下人（げにん）が、\"羅生門\"の下で\n雨やみを待っていた。\n" +
+				"aozora> 吾輩は猫である。名前はまだ無い。\n" +
+				"aozora> error: aozora: not found: work \"犬\"\n" +
+				"aozora> \n",
+		},
+		{
+			name:   "completion",
+			script: "titles 芥\t\ncomplete 羅\n",
+			want:   "aozora> 芥川 竜之介\naozora> 羅生門\naozora> \n",
+		},
+		{
+			name:   "query",
+			script: "query 下人\nquery\n",
+			want: "aozora> 000879   128: 羅生門 (芥川 竜之介)\n    一人の【下人】が、\"羅生門\"の下で雨やみを待っていた。\n[下人] from 1\n" +
+				"aozora> 000879   128: 羅生門 (芥川 竜之介)\n    一人の【下人】が、\"羅生門\"の下で雨やみを待っていた。\n[下人] from 1\n" +
+				"aozora> \n",
+		},
+		{
+			name:   "refine",
+			script: "refine 猫\nquery 猫 OR 下人\nrefine 吾輩\nquery 猫 OR 下人\nexclude 吾輩\n",
+			want: "aozora> error: no query to refine\n" +
+				"aozora> " + hitsOf("猫 OR 下人", 1, "000148", "000879") +
+				"aozora> 000148   789: 吾輩は猫である (夏目 漱石)\n    【吾輩】は【猫】である。名前はまだ無い。\n[(猫 OR 下人) AND 吾輩] from 1\n" +
+				"aozora> " + hitsOf("猫 OR 下人", 1, "000148", "000879") +
+				"aozora> " + hitsOf("(猫 OR 下人) NOT 吾輩", 1, "000879") +
+				"aozora> \n",
+		},
+		{
+			name:   "next",
+			script: "page 1\nquery 猫 OR 下人\nnext\nnext\nprev\n",
+			want: "aozora> aozora> " + hitsOf("猫 OR 下人", 1, "000148") +
+				"aozora> " + hitsOf("猫 OR 下人", 2, "000879") +
+				"aozora> " + hitsOf("猫 OR 下人", 3) +
+				"aozora> " + hitsOf("猫 OR 下人", 2, "000879") +
+				"aozora> \n",
+		},
+		{
+			name:   "content",
+			script: "page 1\ncontent 000879 128 ruby-paren\n\nexit\n",
+			want: "aozora> aozora> 一人の下人（げにん）が、\"羅生門\"の下で\n" +
+				"-- 1/2 lines (Enter: next page, q: quit) --雨やみを待っていた。\n" +
+				"aozora> ",
+		},
+		{
+			name:   "content quit",
+			script: "page 1\ncontent 000879 128\nq\n",
+			want: "aozora> aozora> 一人の下人が、\"羅生門\"の下で\n" +
+				"-- 1/2 lines (Enter: next page, q: quit) --aozora> \n",
+		},
+	}
+	for _, tt := range tests {
+		got := runShell(t, store, tt.script, "")
+		if got != tt.want {
+			t.Errorf("%s: want\n%q\nbut got\n%q", tt.name, tt.want, got)
+		}
+	}
+}
+
+// hitsOf は作者 ID の順に並んだテスト用の作品の検索結果の表示を返す
+func hitsOf(query string, from int, authorIDs ...string) string {
+	lines := map[string]string{
+		"000148": "000148   789: 吾輩は猫である (夏目 漱石)\n    吾輩は【猫】である。名前はまだ無い。\n",
+		"000879": "000879   128: 羅生門 (芥川 竜之介)\n    一人の【下人】が、\"羅生門\"の下で雨やみを待っていた。\n",
+	}
+	var b strings.Builder
+	for _, id := range authorIDs {
+		b.WriteString(lines[id])
+	}
+	b.WriteString("[" + query + "] from " + strconv.Itoa(from) + "\n")
+	return b.String()
+}
+
+func TestShellHistoryFile(t *testing.T) {
+	store := openTestStore(t)
+	path := filepath.Join(t.TempDir(), "history")
+
+	runShell(t, store, "titles 000148\n", path)
+	got := runShell(t, store, "history\n", path)
+	wantOut := "aozora>    1  titles 000148\n   2  history\naozora> \n"
+	if got != wantOut {
+		t.Errorf("want %q, but got %q", wantOut, got)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"titles 000148", "history"}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if !reflect.DeepEqual(want, lines) {
+		t.Errorf("want %v, but got %v", want, lines)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/yuichi04/aozora-search/aozora"
+)
+
+func main() {
+	dsn := flag.String("d", "database.sqlite", "database")
+	addr := flag.String("addr", ":8080", "address to listen on")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), "\nBuild with -tags sqlite_fts5 and run aozora-search migrate-fts5 to rank /search results by bm25.")
+	}
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	store, err := aozora.Open(*dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           newServer(store),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Ctrl+C で処理中のリクエストを終えてから止める
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(sctx)
+	}()
+
+	log.Printf("listening on %s", *addr)
+	err = srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yuichi04/aozora-search/aozora"
+)
+
+// defaultLimit は /search で limit を省略した場合に返す件数
+const defaultLimit = 20
+
+// maxLimit は /search で一度に返す件数の上限
+const maxLimit = 100
+
+// authorJSON は /authors が返す作者
+type authorJSON struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Yomi      string `json:"yomi"`
+	Romaji    string `json:"romaji"`
+	BirthDate string `json:"birth_date"`
+	DeathDate string `json:"death_date"`
+}
+
+// titleJSON は /authors/{id}/titles が返す作品
+type titleJSON struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// workJSON は /works/{author}/{title} が返す作品の情報と本文
+type workJSON struct {
+	AuthorID        string `json:"author_id"`
+	Author          string `json:"author"`
+	TitleID         string `json:"title_id"`
+	Title           string `json:"title"`
+	TitleYomi       string `json:"title_yomi"`
+	Subtitle        string `json:"subtitle"`
+	OriginalTitle   string `json:"original_title"`
+	Translator      string `json:"translator"`
+	FirstAppearance string `json:"first_appearance"`
+	CharType        string `json:"char_type"`
+	Copyright       bool   `json:"copyright"`
+	ReleaseDate     string `json:"release_date"`
+	SiteURL         string `json:"site_url"`
+	Format          string `json:"format"`
+	Content         string `json:"content"`
+}
+
+// hitJSON は /search が返す作品
+type hitJSON struct {
+	AuthorID string `json:"author_id"`
+	Author   string `json:"author"`
+	TitleID  string `json:"title_id"`
+	Title    string `json:"title"`
+	Snippet  string `json:"snippet"`
+}
+
+// searchJSON は /search の結果
+type searchJSON struct {
+	Query  string    `json:"query"`
+	Limit  int       `json:"limit"`
+	Offset int       `json:"offset"`
+	Hits   []hitJSON `json:"hits"`
+}
+
+// errorJSON は失敗した場合に返す
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+// server は aozora-collector で作ったデータベースを JSON で返す
+type server struct {
+	store *aozora.SQLiteStore
+	mux   *http.ServeMux
+}
+
+// newServer は store を返す HTTP ハンドラを作る
+func newServer(store *aozora.SQLiteStore) *server {
+	s := &server{store: store, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /authors", s.handleAuthors)
+	s.mux.HandleFunc("GET /authors/{id}/titles", s.handleTitles)
+	s.mux.HandleFunc("GET /works/{author}/{title}", s.handleWork)
+	s.mux.HandleFunc("GET /search", s.handleSearch)
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// writeJSON は v を JSON にして status で返す
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// writeError は err を JSON にして status で返す。サーバの失敗は理由を隠してログに書く
+func writeError(w http.ResponseWriter, status int, err error) {
+	msg := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Print(err)
+		msg = http.StatusText(status)
+	}
+	writeJSON(w, status, &errorJSON{Error: msg})
+}
+
+func (s *server) handleAuthors(w http.ResponseWriter, r *http.Request) {
+	authors, err := s.store.Authors()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	res := []authorJSON{}
+	for _, a := range authors {
+		res = append(res, authorJSON{
+			ID:        a.ID,
+			Name:      a.Name,
+			Yomi:      a.Yomi,
+			Romaji:    a.Romaji,
+			BirthDate: a.BirthDate,
+			DeathDate: a.DeathDate,
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *server) handleTitles(w http.ResponseWriter, r *http.Request) {
+	titles, err := s.store.Titles(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// 作品の無い作者は保存しないので、作品が無ければ作者も無い
+	if len(titles) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("author not found"))
+		return
+	}
+	res := []titleJSON{}
+	for _, t := range titles {
+		res = append(res, titleJSON{ID: t.ID, Title: t.Title})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleWork は作品の情報と、format の形式 (plain, ruby-paren, html) の本文を返す
+func (s *server) handleWork(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = aozora.FormatPlain
+	}
+	work, err := s.store.Work(r.PathValue("author"), r.PathValue("title"))
+	if errors.Is(err, aozora.ErrNotFound) {
+		writeError(w, http.StatusNotFound, errors.New("work not found"))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	content, err := s.store.Content(work.AuthorID, work.TitleID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	text, err := aozora.ParseText(content).Render(format)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &workJSON{
+		AuthorID:        work.AuthorID,
+		Author:          work.Author,
+		TitleID:         work.TitleID,
+		Title:           work.Title,
+		TitleYomi:       work.TitleYomi,
+		Subtitle:        work.Subtitle,
+		OriginalTitle:   work.OriginalTitle,
+		Translator:      work.Translator,
+		FirstAppearance: work.FirstAppearance,
+		CharType:        work.CharType,
+		Copyright:       work.Copyright,
+		ReleaseDate:     work.ReleaseDate,
+		SiteURL:         work.SiteURL,
+		Format:          format,
+		Content:         text,
+	})
+}
+
+// handleSearch は q で全文検索し、limit と offset の範囲の結果を返す
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := q.Get("q")
+	if query == "" {
+		writeError(w, http.StatusBadRequest, errors.New("q is required"))
+		return
+	}
+	limit, err := intParam(q.Get("limit"), defaultLimit)
+	if err != nil || limit < 1 || limit > maxLimit {
+		writeError(w, http.StatusBadRequest, errors.New("limit must be between 1 and "+strconv.Itoa(maxLimit)))
+		return
+	}
+	offset, err := intParam(q.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("offset must not be negative"))
+		return
+	}
+
+	hits, err := s.store.SearchWith(query, aozora.SearchOptions{Limit: limit, Offset: offset, Open: markOpen, Close: markClose})
+	if err != nil {
+		// 検索式の構文の誤りも SQLite のエラーになる
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	res := &searchJSON{Query: query, Limit: limit, Offset: offset, Hits: []hitJSON{}}
+	for _, hit := range hits {
+		res.Hits = append(res.Hits, hitJSON{
+			AuthorID: hit.AuthorID,
+			Author:   hit.Author,
+			TitleID:  hit.TitleID,
+			Title:    hit.Title,
+			Snippet:  snippetHTML(hit.Snippet),
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// markOpen と markClose はスニペットの中でマッチした語を囲む目印
+//
+// 本文には < や & が含まれることがあるので、目印で囲んだスニペットを
+// HTML としてエスケープしてから、目印を <mark> に置き換える。
+const (
+	markOpen  = "\x01"
+	markClose = "\x02"
+)
+
+var markReplacer = strings.NewReplacer(markOpen, "<mark>", markClose, "</mark>")
+
+// snippetHTML は目印で囲んだスニペットを HTML にする
+func snippetHTML(snippet string) string {
+	return markReplacer.Replace(html.EscapeString(snippet))
+}
+
+// intParam はクエリパラメータを整数にする。空の場合は def を返す
+func intParam(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yuichi04/aozora-search/aozora"
+)
+
+// newTestServer はテスト用の作品を登録したデータベースを返すサーバを起動する
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	store, err := aozora.Open(filepath.Join(t.TempDir(), "database.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+	})
+
+	fixtures := []struct {
+		entry   aozora.Entry
+		content string
+	}{
+		{
+			entry: aozora.Entry{
+				AuthorID: "000879", Author: "芥川 竜之介", AuthorYomi: "あくたがわ りゅうのすけ",
+				TitleID: "128", Title: "羅生門", TitleYomi: "らしょうもん",
+				FirstAppearance: "「帝国文学」1915（大正4）年11月", CharType: "新字旧仮名",
+				SiteURL: "https://www.aozora.gr.jp/cards/000879/card128.html",
+			},
+			content: "一人の下人《げにん》が羅生門の下で雨やみを待っていた。",
+		},
+		{
+			entry:   aozora.Entry{AuthorID: "000879", Author: "芥川 竜之介", TitleID: "92", Title: "蜘蛛の糸"},
+			content: "ある日の事でございます。御釈迦様は極楽の蓮池のふちを歩いていらっしゃいました。",
+		},
+		{
+			entry:   aozora.Entry{AuthorID: "000148", Author: "夏目 漱石", TitleID: "789", Title: "吾輩は猫である"},
+			content: "吾輩は猫である。名前はまだ無い。吾輩の主人は教師である。",
+		},
+	}
+	for _, f := range fixtures {
+		err = store.AddEntry(&f.entry, f.content)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ts := httptest.NewServer(newServer(store))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// getJSON は path を GET し、ステータスコードと JSON を v に読み込む
+func getJSON(t *testing.T, ts *httptest.Server, path string, v any) int {
+	t.Helper()
+
+	resp, err := http.Get(ts.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("%s: want JSON, but got %q", path, ct)
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestAuthors(t *testing.T) {
+	ts := newTestServer(t)
+
+	var got []authorJSON
+	status := getJSON(t, ts, "/authors", &got)
+	if status != http.StatusOK {
+		t.Fatalf("want %v, but got %v", http.StatusOK, status)
+	}
+	want := []authorJSON{
+		{ID: "000148", Name: "夏目 漱石"},
+		{ID: "000879", Name: "芥川 竜之介", Yomi: "あくたがわ りゅうのすけ"},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %+v, but got %+v", want, got)
+	}
+}
+
+func TestTitles(t *testing.T) {
+	ts := newTestServer(t)
+
+	var got []titleJSON
+	status := getJSON(t, ts, "/authors/000879/titles", &got)
+	if status != http.StatusOK {
+		t.Fatalf("want %v, but got %v", http.StatusOK, status)
+	}
+	want := []titleJSON{{ID: "92", Title: "蜘蛛の糸"}, {ID: "128", Title: "羅生門"}}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %+v, but got %+v", want, got)
+	}
+
+	var e errorJSON
+	status = getJSON(t, ts, "/authors/999999/titles", &e)
+	if status != http.StatusNotFound {
+		t.Errorf("want %v, but got %v", http.StatusNotFound, status)
+	}
+}
+
+func TestWork(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		path    string
+		status  int
+		format  string
+		content string
+	}{
+		{path: "/works/000879/128", status: http.StatusOK, format: "plain", content: "一人の下人が羅生門の下で雨やみを待っていた。"},
+		{path: "/works/000879/128?format=ruby-paren", status: http.StatusOK, format: "ruby-paren", content: "一人の下人（げにん）が羅生門の下で雨やみを待っていた。"},
+		{path: "/works/000879/128?format=html", status: http.StatusOK, format: "html", content: "一人の<ruby><rb>下人</rb><rt>げにん</rt></ruby>が羅生門の下で雨やみを待っていた。"},
+		{path: "/works/000879/128?format=markdown", status: http.StatusBadRequest},
+		{path: "/works/000879/999", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		var got workJSON
+		status := getJSON(t, ts, tt.path, &got)
+		if status != tt.status {
+			t.Errorf("%s: want %v, but got %v", tt.path, tt.status, status)
+			continue
+		}
+		if status != http.StatusOK {
+			continue
+		}
+		if got.Format != tt.format || got.Content != tt.content {
+			t.Errorf("%s: want %s %q, but got %s %q", tt.path, tt.format, tt.content, got.Format, got.Content)
+		}
+		if got.Title != "羅生門" || got.CharType != "新字旧仮名" || got.FirstAppearance != "「帝国文学」1915（大正4）年11月" {
+			t.Errorf("%s: want metadata of 羅生門, but got %+v", tt.path, got)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ts := newTestServer(t)
+
+	var got searchJSON
+	status := getJSON(t, ts, "/search?q=羅生門", &got)
+	if status != http.StatusOK {
+		t.Fatalf("want %v, but got %v", http.StatusOK, status)
+	}
+	want := searchJSON{
+		Query: "羅生門",
+		Limit: defaultLimit,
+		Hits: []hitJSON{{
+			AuthorID: "000879",
+			Author:   "芥川 竜之介",
+			TitleID:  "128",
+			Title:    "羅生門",
+			Snippet:  "一人の下人が<mark>羅生門</mark>の下で雨やみを待っていた。",
+		}},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %+v, but got %+v", want, got)
+	}
+
+	// の は三作品すべてにある
+	tests := []struct {
+		path  string
+		count int
+	}{
+		{path: "/search?q=の", count: 3},
+		{path: "/search?q=の&limit=2", count: 2},
+		{path: "/search?q=の&limit=2&offset=2", count: 1},
+		{path: "/search?q=の&offset=3", count: 0},
+	}
+	for _, tt := range tests {
+		var got searchJSON
+		status := getJSON(t, ts, tt.path, &got)
+		if status != http.StatusOK {
+			t.Errorf("%s: want %v, but got %v", tt.path, http.StatusOK, status)
+			continue
+		}
+		if len(got.Hits) != tt.count {
+			t.Errorf("%s: want %d hits, but got %+v", tt.path, tt.count, got.Hits)
+		}
+	}
+
+	for _, path := range []string{"/search", "/search?q=の&limit=0", "/search?q=の&limit=x", "/search?q=の&offset=-1"} {
+		var e errorJSON
+		status := getJSON(t, ts, path, &e)
+		if status != http.StatusBadRequest {
+			t.Errorf("%s: want %v, but got %v", path, http.StatusBadRequest, status)
+		}
+		if e.Error == "" {
+			t.Errorf("%s: want error message", path)
+		}
+	}
+}
+
+func TestSearchEscapesSnippet(t *testing.T) {
+	store, err := aozora.Open(filepath.Join(t.TempDir(), "database.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	entry := aozora.Entry{AuthorID: "999999", Author: "テスト 太郎", TitleID: "001", Title: "テスト書籍001"}
+	err = store.AddEntry(&entry, "本文に<script>alert(1)</script>と書いた。")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(newServer(store))
+	defer ts.Close()
+
+	var got searchJSON
+	status := getJSON(t, ts, "/search?q=本文", &got)
+	if status != http.StatusOK {
+		t.Fatalf("want %v, but got %v", http.StatusOK, status)
+	}
+	if len(got.Hits) != 1 {
+		t.Fatalf("want 1 hit, but got %+v", got.Hits)
+	}
+
+	// 本文の < はエスケープし、マッチした語だけを <mark> で囲む
+	want := "<mark>本文</mark>に&lt;script&gt;alert(1)&lt;/script&gt;と書いた。"
+	if got.Hits[0].Snippet != want {
+		t.Errorf("want %q, but got %q", want, got.Hits[0].Snippet)
+	}
+}
@@ -5,11 +5,11 @@ go 1.22.4
 require github.com/ikawaha/kagome/v2 v2.9.11
 
 require (
-	github.com/PuerkitoBio/goquery v1.9.2 // indirect
+	github.com/PuerkitoBio/goquery v1.9.2
 	github.com/andybalholm/cascadia v1.3.2 // indirect
 	github.com/ikawaha/kagome-dict v1.1.0 // indirect
 	github.com/ikawaha/kagome-dict/ipa v1.2.0
 	github.com/mattn/go-sqlite3 v1.14.22
 	golang.org/x/net v0.24.0 // indirect
-	golang.org/x/text v0.16.0 // indirect
+	golang.org/x/text v0.16.0
 )
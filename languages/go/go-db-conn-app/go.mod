@@ -3,9 +3,15 @@ module go-db-conn-app
 go 1.22.4
 
 require (
-	github.com/ikawaha/kagome-dict v1.1.0 // indirect
-	github.com/ikawaha/kagome-dict/ipa v1.2.0
-	github.com/ikawaha/kagome/v2 v2.9.11
-	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/yuichi04/aozora-search v0.0.0
 	golang.org/x/text v0.16.0
 )
+
+require (
+	github.com/ikawaha/kagome-dict v1.1.0 // indirect
+	github.com/ikawaha/kagome-dict/ipa v1.2.0 // indirect
+	github.com/ikawaha/kagome/v2 v2.9.11 // indirect
+	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+)
+
+replace github.com/yuichi04/aozora-search => ../go-cli-app